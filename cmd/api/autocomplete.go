@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"greenlight.example.com/internal/data"
+	"greenlight.example.com/internal/validator"
+)
+
+// Add an autocompleteHandler for the "GET /v1/autocomplete" endpoint, which returns the
+// top suggestions for a partially typed search.
+func (app *application) autocompleteHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Q     string
+		Limit int
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Q = strings.TrimSpace(app.readString(qs, "q", ""))
+	input.Limit = app.readInt(qs, "limit", 10, v)
+
+	if data.ValidateAutocomplete(v, input.Q, input.Limit); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Movies.Autocomplete(input.Q, input.Limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
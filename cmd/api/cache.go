@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ttlCacheMaxEntries caps the number of entries held by a ttlCache, so that a stream of
+// distinct keys can't make it grow without bound between sweeps.
+const ttlCacheMaxEntries = 1000
+
+// A ttlCache is a simple in-memory cache whose entries expire after a fixed time-to-live.
+// It is safe for concurrent use. A zero TTL disables the cache, so that nothing is ever
+// stored in it.
+type ttlCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]ttlCacheEntry
+}
+
+type ttlCacheEntry struct {
+	value   any
+	expires time.Time
+}
+
+func newTTLCache(ttl time.Duration) *ttlCache {
+	c := &ttlCache{ttl: ttl, entries: make(map[string]ttlCacheEntry)}
+
+	// As with the rate limiter, launch a background goroutine which removes expired
+	// entries from the map once every TTL (or once a minute, if the TTL is longer).
+	if ttl > 0 {
+		go func() {
+			for {
+				time.Sleep(min(ttl, time.Minute))
+
+				c.mu.Lock()
+				now := time.Now()
+				for key, entry := range c.entries {
+					if now.After(entry.expires) {
+						delete(c.entries, key)
+					}
+				}
+				c.mu.Unlock()
+			}
+		}()
+	}
+
+	return c
+}
+
+// Get returns the value stored under the given key, if there is one and it hasn't
+// expired.
+func (c *ttlCache) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	return entry.value, true
+}
+
+// Set stores a value under the given key. If the cache is full, the entry which expires
+// soonest is evicted to make room for it.
+func (c *ttlCache) Set(key string, value any) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= ttlCacheMaxEntries {
+		var oldestKey string
+		var oldest time.Time
+
+		for k, entry := range c.entries {
+			if oldest.IsZero() || entry.expires.Before(oldest) {
+				oldestKey, oldest = k, entry.expires
+			}
+		}
+
+		delete(c.entries, oldestKey)
+	}
+
+	c.entries[key] = ttlCacheEntry{value: value, expires: time.Now().Add(c.ttl)}
+}
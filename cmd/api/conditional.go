@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight.example.com/internal/data"
+)
+
+// movieETag() returns a strong ETag for a single movie. The version number is bumped on
+// every update, so the ID and version identify the movie's own data. Translations and
+// collections are stored separately and can change without the version being bumped, so
+// the tag also holds a hash of the localized title and overview and the collections, if
+// they were loaded, along with the sparse fieldset (if any). The locale of any translation
+// which was applied and the response format are included as well, as the same movie can be
+// sent in different representations.
+func movieETag(movie *data.Movie, fields []string, format responseFormat) string {
+	tag := fmt.Sprintf("%d-%d", movie.ID, movie.Version)
+
+	if movie.Language != "" {
+		tag += "-" + movie.Language
+	}
+
+	sorted := slices.Clone(fields)
+	slices.Sort(sorted)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %q %q\n", movie.Title, movie.Overview, strings.Join(sorted, ","))
+	for _, collection := range movie.Collections {
+		fmt.Fprintf(h, "%d %q %d\n", collection.ID, collection.Name, collection.Position)
+	}
+
+	tag += "-" + hex.EncodeToString(h.Sum(nil)[:8])
+
+	if format.name != formatJSON.name {
+		tag += "-" + format.name
+	}
+
+	return `"` + tag + `"`
+}
+
+// weakETag() returns a weak ETag for a response body, computed from a hash of its JSON
+// encoding and the name of the format it will be sent in. It is used for lists, which
+// don't have a version number of their own.
+func weakETag(data envelope, format responseFormat) (string, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(append(js, format.name...))
+
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// etagMatches() reports whether an If-None-Match header value matches the given ETag.
+// The header can hold "*" or a comma-separated list of ETags, and as RFC 9110 requires
+// for If-None-Match they are compared weakly, ignoring any W/ prefix.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified() reports whether a GET request's preconditions show that the client's
+// cached copy is still current, so that a 304 Not Modified response can be sent. If the
+// request has an If-None-Match header then only that is checked; otherwise the
+// If-Modified-Since header is compared with lastModified, if it isn't zero.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		return etagMatches(header, etag)
+	}
+
+	if header := r.Header.Get("If-Modified-Since"); header != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(header)
+		if err != nil {
+			return false
+		}
+
+		// HTTP dates only have a resolution of one second.
+		return !lastModified.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
+
+// The notModifiedResponse() method sends a 304 Not Modified response with no body. The
+// ETag, Last-Modified, Vary and other headers are still sent, as they would have been in a
+// 200 OK response.
+func (app *application) notModifiedResponse(w http.ResponseWriter, headers http.Header) {
+	setHeaders(w, headers)
+
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// The checkMoviePrecondition() method checks the If-Match and X-Expected-Version headers
+// of a PATCH or DELETE request against the current version of the movie. It returns
+// true if the request can go ahead, or sends an error response and returns false if it
+// can't.
+//
+// The If-Match header holds "*" or a list of the ETags returned by movieETag(), and as
+// RFC 9110 requires they are compared strongly, so weak ETags never match. Only the ID
+// and version are compared, so an ETag from a localized or sparse representation of the
+// current version still matches. The X-Expected-Version header is a simpler alternative
+// for clients which hold the version number rather than the ETag. If neither header is
+// given then the request goes ahead, unless preconditions are required by the config.
+func (app *application) checkMoviePrecondition(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	ifMatch := r.Header.Get("If-Match")
+	expectedVersion := r.Header.Get("X-Expected-Version")
+
+	if ifMatch == "" && expectedVersion == "" {
+		if app.config.preconditions.required {
+			app.preconditionRequiredResponse(w, r)
+			return false
+		}
+		return true
+	}
+
+	matched := true
+
+	if ifMatch != "" {
+		matched = etagMatchesVersion(ifMatch, movie)
+	}
+
+	if expectedVersion != "" {
+		version, err := strconv.ParseInt(expectedVersion, 10, 32)
+		if err != nil || version < 1 {
+			app.badRequestResponse(w, r, errors.New("X-Expected-Version header must be a positive integer"))
+			return false
+		}
+
+		matched = matched && int32(version) == movie.Version
+	}
+
+	if !matched {
+		// Include the current ETag, so that the client knows what it is up against.
+		w.Header().Set("ETag", movieETag(movie, nil, formatJSON))
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// etagMatchesVersion() reports whether an If-Match header value matches the current
+// version of the movie.
+func etagMatchesVersion(header string, movie *data.Movie) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" {
+			return true
+		}
+
+		if strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+
+		// The ETag is in the form "id-version", optionally followed by more parts for the
+		// representation.
+		parts := strings.Split(strings.Trim(candidate, `"`), "-")
+		if len(parts) >= 2 && parts[0] == strconv.FormatInt(movie.ID, 10) && parts[1] == strconv.FormatInt(int64(movie.Version), 10) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"greenlight.example.com/internal/data"
+)
+
+// newTestApplication returns an application with a logger which discards its output, for
+// tests which call its methods directly.
+func newTestApplication() *application {
+	return &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+}
+
+func TestCheckMoviePrecondition(t *testing.T) {
+	movie := &data.Movie{ID: 7, Version: 3, Title: "Heat"}
+	etag := movieETag(movie, nil, formatJSON)
+
+	tests := []struct {
+		name       string
+		required   bool
+		headers    map[string]string
+		wantOK     bool
+		wantStatus int
+		wantETag   bool
+	}{
+		{
+			name:   "No preconditions",
+			wantOK: true,
+		},
+		{
+			name:       "No preconditions when required",
+			required:   true,
+			wantStatus: http.StatusPreconditionRequired,
+		},
+		{
+			name:    "Matching ETag",
+			headers: map[string]string{"If-Match": etag},
+			wantOK:  true,
+		},
+		{
+			name:    "ETag of another representation",
+			headers: map[string]string{"If-Match": `"7-3-fr-0123456789abcdef-xml"`},
+			wantOK:  true,
+		},
+		{
+			name:    "One of several ETags",
+			headers: map[string]string{"If-Match": `"7-2-aaaa", ` + etag},
+			wantOK:  true,
+		},
+		{
+			name:     "Star",
+			required: true,
+			headers:  map[string]string{"If-Match": "*"},
+			wantOK:   true,
+		},
+		{
+			name:       "Stale ETag",
+			headers:    map[string]string{"If-Match": `"7-2-aaaa"`},
+			wantStatus: http.StatusPreconditionFailed,
+			wantETag:   true,
+		},
+		{
+			name:       "ETag of another movie",
+			headers:    map[string]string{"If-Match": `"8-3-aaaa"`},
+			wantStatus: http.StatusPreconditionFailed,
+			wantETag:   true,
+		},
+		{
+			name:       "Weak ETag never matches",
+			headers:    map[string]string{"If-Match": "W/" + etag},
+			wantStatus: http.StatusPreconditionFailed,
+			wantETag:   true,
+		},
+		{
+			name:    "Matching version",
+			headers: map[string]string{"X-Expected-Version": "3"},
+			wantOK:  true,
+		},
+		{
+			name:       "Stale version",
+			headers:    map[string]string{"X-Expected-Version": "2"},
+			wantStatus: http.StatusPreconditionFailed,
+			wantETag:   true,
+		},
+		{
+			name:       "Invalid version",
+			headers:    map[string]string{"X-Expected-Version": "three"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Zero version",
+			headers:    map[string]string{"X-Expected-Version": "0"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Both must match",
+			headers:    map[string]string{"If-Match": etag, "X-Expected-Version": "2"},
+			wantStatus: http.StatusPreconditionFailed,
+			wantETag:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication()
+			app.config.preconditions.required = tt.required
+
+			r := httptest.NewRequest(http.MethodPatch, "/v1/movies/7", nil)
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+
+			ok := app.checkMoviePrecondition(w, r, movie)
+
+			if ok != tt.wantOK {
+				t.Fatalf("got %t; want %t", ok, tt.wantOK)
+			}
+			if ok {
+				if w.Body.Len() != 0 {
+					t.Errorf("got a response %q; want none", w.Body.String())
+				}
+				return
+			}
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("ETag"); (got == etag) != tt.wantETag {
+				t.Errorf("got ETag %q; want it sent: %t", got, tt.wantETag)
+			}
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	lastModified := time.Date(2024, 3, 1, 12, 30, 15, 500_000_000, time.UTC)
+	etag := `"7-3-0123456789abcdef"`
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{
+			name: "No preconditions",
+			want: false,
+		},
+		{
+			name:    "Matching ETag",
+			headers: map[string]string{"If-None-Match": etag},
+			want:    true,
+		},
+		{
+			name:    "Weak comparison",
+			headers: map[string]string{"If-None-Match": "W/" + etag},
+			want:    true,
+		},
+		{
+			name:    "One of several ETags",
+			headers: map[string]string{"If-None-Match": `"7-2-aaaa", ` + etag},
+			want:    true,
+		},
+		{
+			name:    "Star",
+			headers: map[string]string{"If-None-Match": "*"},
+			want:    true,
+		},
+		{
+			name:    "Stale ETag",
+			headers: map[string]string{"If-None-Match": `"7-2-aaaa"`},
+			want:    false,
+		},
+		{
+			name:    "Not modified since",
+			headers: map[string]string{"If-Modified-Since": lastModified.Format(http.TimeFormat)},
+			want:    true,
+		},
+		{
+			name:    "Modified since",
+			headers: map[string]string{"If-Modified-Since": lastModified.Add(-time.Second).Format(http.TimeFormat)},
+			want:    false,
+		},
+		{
+			name:    "Invalid date",
+			headers: map[string]string{"If-Modified-Since": "yesterday"},
+			want:    false,
+		},
+		{
+			name: "If-None-Match takes precedence",
+			headers: map[string]string{
+				"If-None-Match":     `"7-2-aaaa"`,
+				"If-Modified-Since": lastModified.Format(http.TimeFormat),
+			},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies/7", nil)
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+
+			if got := notModified(r, etag, lastModified); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMovieETag(t *testing.T) {
+	movie := &data.Movie{ID: 7, Version: 3, Title: "Heat"}
+	etag := movieETag(movie, nil, formatJSON)
+
+	if !strings.HasPrefix(etag, `"7-3-`) {
+		t.Errorf("got %s; want it to start with the ID and version", etag)
+	}
+
+	localized := *movie
+	localized.Title, localized.Language = "Heat (fr)", "fr"
+
+	tests := []struct {
+		name  string
+		etag  string
+		equal bool
+	}{
+		{name: "Same representation", etag: movieETag(movie, nil, formatJSON), equal: true},
+		{name: "Sparse fieldset", etag: movieETag(movie, []string{"title"}, formatJSON), equal: false},
+		{name: "Translation", etag: movieETag(&localized, nil, formatJSON), equal: false},
+		{name: "Format", etag: movieETag(movie, nil, formatXML), equal: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if (tt.etag == etag) != tt.equal {
+				t.Errorf("got %s; want equal to %s: %t", tt.etag, etag, tt.equal)
+			}
+		})
+	}
+
+	if movieETag(movie, []string{"year", "title"}, formatJSON) != movieETag(movie, []string{"title", "year"}, formatJSON) {
+		t.Error("got different ETags for the same fields in another order")
+	}
+}
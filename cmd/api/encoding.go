@@ -0,0 +1,580 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// A responseFormat is one of the formats which responses can be encoded in, chosen by
+// content negotiation on the Accept header. The first of its media types is sent in the
+// Content-Type header, and any of them can be used to ask for it.
+type responseFormat struct {
+	name       string
+	mediaTypes []string
+	listOnly   bool // Whether the format can only be used for lists, like CSV
+}
+
+var (
+	formatJSON    = responseFormat{name: "json", mediaTypes: []string{mediaTypeJSON}}
+	formatHAL     = responseFormat{name: "hal", mediaTypes: []string{"application/hal+json"}}
+	formatXML     = responseFormat{name: "xml", mediaTypes: []string{"application/xml", "text/xml"}}
+	formatCSV     = responseFormat{name: "csv", mediaTypes: []string{"text/csv"}, listOnly: true}
+	formatMsgPack = responseFormat{name: "msgpack", mediaTypes: []string{"application/msgpack", "application/vnd.msgpack", "application/x-msgpack"}}
+)
+
+// The responseFormats slice holds the supported formats in order of preference, which is
+// used to break ties between formats which the client accepts equally.
+var responseFormats = []responseFormat{formatJSON, formatHAL, formatXML, formatCSV, formatMsgPack}
+
+// A mediaRange is one of the media ranges in an Accept header, with its q-value.
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept() returns the media ranges in the Accept headers of a request. Any which
+// can't be parsed are skipped.
+func parseAccept(r *http.Request) []mediaRange {
+	var ranges []mediaRange
+
+	for _, value := range strings.Split(strings.Join(r.Header.Values("Accept"), ","), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if qValue, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(qValue, 64)
+			if err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+
+		ranges = append(ranges, mediaRange{mediaType, q})
+	}
+
+	return ranges
+}
+
+// negotiateFormat() picks the response format which best matches the Accept header of a
+// request, considering CSV only if the response is a list. It returns false if none of the
+// supported formats are acceptable. If there is no Accept header then JSON is used.
+//
+// The application/problem+json media type only opts in to problem details for errors (see
+// errorResponseWith()), so it is ignored here, and a client which accepts nothing else
+// gets JSON.
+func negotiateFormat(r *http.Request, list bool) (responseFormat, bool) {
+	if len(r.Header.Values("Accept")) == 0 {
+		return formatJSON, true
+	}
+
+	ranges := slices.DeleteFunc(parseAccept(r), func(mr mediaRange) bool {
+		return mr.mediaType == "application/problem+json"
+	})
+	if len(ranges) == 0 {
+		return formatJSON, true
+	}
+
+	// For each format, find the q-value of the most specific media range which matches
+	// it, where an exact match beats "type/*", which beats "*/*".
+	qualityOf := func(format responseFormat) float64 {
+		quality, specificity := 0.0, -1
+
+		for _, mediaType := range format.mediaTypes {
+			for _, mr := range ranges {
+				s := -1
+				switch {
+				case mr.mediaType == mediaType:
+					s = 2
+				case strings.HasSuffix(mr.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mr.mediaType, "*")):
+					s = 1
+				case mr.mediaType == "*/*":
+					s = 0
+				}
+
+				if s > specificity {
+					quality, specificity = mr.q, s
+				}
+			}
+		}
+
+		return quality
+	}
+
+	best, bestQuality := responseFormat{}, 0.0
+
+	for _, format := range responseFormats {
+		if format.listOnly && !list {
+			continue
+		}
+
+		if quality := qualityOf(format); quality > bestQuality {
+			best, bestQuality = format, quality
+		}
+	}
+
+	return best, bestQuality > 0
+}
+
+// The writeResponse() method encodes the data in the format chosen by the request's Accept
+// header, and sends it with the given status code and headers. It is used in place of
+// writeJSON() by every endpoint except GraphQL, which is always JSON.
+//
+// JSON and HAL are written straight from the data by writeJSON(). The other formats are
+// built from the data's JSON, decoded into a tree which keeps the order of the keys, so
+// that they all have the same fields as the JSON, and custom encodings like Runtime's
+// "N mins" are the same in every format. CSV can only be used when the data holds a
+// single list of objects, which becomes the rows; any other data, such as the metadata,
+// is left out.
+//
+// If none of the formats are acceptable, then a 406 Not Acceptable response is sent for
+// GET requests. For other requests the action has already been carried out, so rather
+// than hiding the result the response is sent as JSON anyway, as it is for errors.
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	// Error responses are never sent as CSV, even if they hold a single list (like the
+	// duplicates of a movie which couldn't be created), as the rows would leave out the
+	// error message.
+	format, ok := negotiateFormat(r, status < 400 && isList(data))
+	if !ok {
+		if status < 400 && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			app.notAcceptableResponse(w, r)
+			return nil
+		}
+		format = formatJSON
+	}
+
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	if !slices.Contains(headers.Values("Vary"), "Accept") {
+		headers.Add("Vary", "Accept")
+	}
+
+	// HAL is JSON with _links, which the handlers add for it, so it only differs from
+	// JSON in its Content-Type.
+	switch format.name {
+	case formatJSON.name:
+		return app.writeJSON(w, status, data, headers)
+	case formatHAL.name:
+		headers.Set("Content-Type", "application/hal+json")
+		return app.writeJSON(w, status, data, headers)
+	}
+
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	tree, err := decodeOrdered(js)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+
+	switch format.name {
+	case formatXML.name:
+		err = encodeXML(&buf, tree)
+	case formatCSV.name:
+		err = encodeCSV(&buf, listRows(tree))
+	case formatMsgPack.name:
+		err = encodeMsgPack(&buf, tree)
+	}
+	if err != nil {
+		return err
+	}
+
+	setHeaders(w, headers)
+
+	contentType := format.mediaTypes[0]
+	if format.name != formatMsgPack.name {
+		contentType += "; charset=utf-8"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+
+	return nil
+}
+
+// isList() reports whether the data can be sent as CSV, which is when exactly one of its
+// values is a list of objects: a non-nil slice of structs or maps, or of pointers to them.
+// This matches listRows() on the data's JSON, without having to encode it first.
+func isList(data envelope) bool {
+	lists := 0
+
+	for _, value := range data {
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Slice || rv.IsNil() {
+			continue
+		}
+
+		elem := rv.Type().Elem()
+		if elem.Kind() == reflect.Pointer {
+			elem = elem.Elem()
+		}
+
+		if elem.Kind() == reflect.Struct || elem.Kind() == reflect.Map {
+			lists++
+		}
+	}
+
+	return lists == 1
+}
+
+// An orderedObject is a JSON object decoded with its keys in their original order, so that
+// the fields of the other formats come out in the same order as the JSON.
+type orderedObject []orderedField
+
+type orderedField struct {
+	key   string
+	value any
+}
+
+// MarshalJSON() encodes the object back to JSON, keeping the order of its keys.
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, err := json.Marshal(field.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// decodeOrdered() decodes JSON into a tree of orderedObject, []any, string, json.Number,
+// bool and nil values.
+func decodeOrdered(js []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	var decode func() (any, error)
+
+	decode = func() (any, error) {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch token {
+		case json.Delim('{'):
+			object := orderedObject{}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+
+				value, err := decode()
+				if err != nil {
+					return nil, err
+				}
+
+				object = append(object, orderedField{key.(string), value})
+			}
+			_, err = dec.Token()
+			return object, err
+
+		case json.Delim('['):
+			array := []any{}
+			for dec.More() {
+				value, err := decode()
+				if err != nil {
+					return nil, err
+				}
+
+				array = append(array, value)
+			}
+			_, err = dec.Token()
+			return array, err
+		}
+
+		return token, nil
+	}
+
+	return decode()
+}
+
+// listRows() returns the rows for a CSV response, if the tree is an object holding exactly
+// one list of objects. Otherwise it returns nil.
+func listRows(tree any) []orderedObject {
+	object, ok := tree.(orderedObject)
+	if !ok {
+		return nil
+	}
+
+	var rows []orderedObject
+	lists := 0
+
+	for _, field := range object {
+		array, ok := field.value.([]any)
+		if !ok {
+			continue
+		}
+
+		objects := make([]orderedObject, 0, len(array))
+		for _, item := range array {
+			if itemObject, ok := item.(orderedObject); ok {
+				objects = append(objects, itemObject)
+			}
+		}
+
+		if len(objects) == len(array) {
+			rows = objects
+			lists++
+		}
+	}
+
+	if lists != 1 {
+		return nil
+	}
+
+	return rows
+}
+
+// encodeCSV() writes the rows as CSV with a header line. The columns are the keys of all
+// of the rows, in order of first appearance. Lists of values, like the genres, are joined
+// with semicolons, and any other nested values are written as JSON.
+func encodeCSV(w io.Writer, rows []orderedObject) error {
+	var columns []string
+	index := make(map[string]int)
+
+	for _, row := range rows {
+		for _, field := range row {
+			if _, ok := index[field.key]; !ok {
+				index[field.key] = len(columns)
+				columns = append(columns, field.key)
+			}
+		}
+	}
+
+	if len(columns) == 0 {
+		return nil
+	}
+
+	cw := csv.NewWriter(w)
+
+	err := cw.Write(columns)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		record := make([]string, len(columns))
+
+		for _, field := range row {
+			record[index[field.key]], err = csvValue(field.value)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvValue(value any) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case []any:
+		values := make([]string, len(value))
+		for i, item := range value {
+			switch item.(type) {
+			case orderedObject, []any:
+				js, err := json.Marshal(value)
+				return string(js), err
+			}
+
+			values[i], _ = csvValue(item)
+		}
+		return strings.Join(values, ";"), nil
+	default:
+		js, err := json.Marshal(value)
+		return string(js), err
+	}
+}
+
+// xmlNameRX matches the keys which can be used as XML element names as they are.
+var xmlNameRX = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// encodeXML() writes the tree as an XML document with a <response> root element. Object
+// keys become elements, and the items in a list are elements named for the list's key
+// without its trailing "s", such as <genre> in <genres>, or <item> if it has no "s".
+// Keys which aren't valid element names, like those in facet counts, are written as
+// <entry key="..."> elements instead.
+func encodeXML(w io.Writer, tree any) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+
+	var encode func(name string, value any) error
+
+	encode = func(name string, value any) error {
+		start := xml.StartElement{Name: xml.Name{Local: name}}
+		if !xmlNameRX.MatchString(name) || strings.HasPrefix(strings.ToLower(name), "xml") {
+			start = xml.StartElement{
+				Name: xml.Name{Local: "entry"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}},
+			}
+		}
+
+		err := enc.EncodeToken(start)
+		if err != nil {
+			return err
+		}
+
+		switch value := value.(type) {
+		case orderedObject:
+			for _, field := range value {
+				err = encode(field.key, field.value)
+				if err != nil {
+					return err
+				}
+			}
+		case []any:
+			itemName := "item"
+			if strings.HasSuffix(name, "s") && len(name) > 1 {
+				itemName = strings.TrimSuffix(name, "s")
+			}
+
+			for _, item := range value {
+				err = encode(itemName, item)
+				if err != nil {
+					return err
+				}
+			}
+		case nil:
+		default:
+			text, _ := csvValue(value)
+			err = enc.EncodeToken(xml.CharData(text))
+			if err != nil {
+				return err
+			}
+		}
+
+		return enc.EncodeToken(start.End())
+	}
+
+	err = encode("response", tree)
+	if err != nil {
+		return err
+	}
+
+	err = enc.Flush()
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// encodeMsgPack() writes the tree in the MessagePack format, keeping the order of the
+// keys. Numbers are written as integers where possible, and as 64-bit floats otherwise.
+func encodeMsgPack(w io.Writer, tree any) error {
+	enc := msgpack.NewEncoder(w)
+
+	var encode func(value any) error
+
+	encode = func(value any) error {
+		switch value := value.(type) {
+		case nil:
+			return enc.EncodeNil()
+		case bool:
+			return enc.EncodeBool(value)
+		case string:
+			return enc.EncodeString(value)
+		case json.Number:
+			if n, err := value.Int64(); err == nil {
+				return enc.EncodeInt(n)
+			}
+
+			f, err := value.Float64()
+			if err != nil {
+				return err
+			}
+			return enc.EncodeFloat64(f)
+		case []any:
+			err := enc.EncodeArrayLen(len(value))
+			if err != nil {
+				return err
+			}
+			for _, item := range value {
+				err = encode(item)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		case orderedObject:
+			err := enc.EncodeMapLen(len(value))
+			if err != nil {
+				return err
+			}
+			for _, field := range value {
+				err = enc.EncodeString(field.key)
+				if err != nil {
+					return err
+				}
+				err = encode(field.value)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		default:
+			return fmt.Errorf("msgpack: unsupported value type %T", value)
+		}
+	}
+
+	return encode(tree)
+}
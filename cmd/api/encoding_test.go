@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestEncodeMsgPack(t *testing.T) {
+	tests := []struct {
+		name string
+		js   string
+	}{
+		{name: "Null", js: `null`},
+		{name: "Booleans", js: `[true, false]`},
+		{name: "Small integers", js: `[0, 1, 127, -1, -32]`},
+		{name: "Large integers", js: `[128, 255, 65536, -33, -129, 9223372036854775807, -9223372036854775808]`},
+		{name: "Floats", js: `[1.5, -0.25, 3.141592653589793]`},
+		{name: "Strings", js: `["", "drama", "amélie", "` + string(bytes.Repeat([]byte("x"), 300)) + `"]`},
+		{name: "Empty containers", js: `{"a": [], "b": {}}`},
+		{name: "Nested", js: `{"movie": {"id": 1, "title": "Casablanca", "genres": ["drama", "romance"], "runtime": "102 mins"}, "metadata": null}`},
+		{name: "Long array", js: `[` + string(bytes.Repeat([]byte("1,"), 20)) + `1]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := decodeOrdered([]byte(tt.js))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			err = encodeMsgPack(&buf, tree)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var decoded any
+			err = msgpack.Unmarshal(buf.Bytes(), &decoded)
+			if err != nil {
+				t.Fatalf("decoding: %v", err)
+			}
+
+			// Compare through JSON, which sorts the keys of both and formats the numbers
+			// the same way, whatever types they were decoded as.
+			var want any
+			dec := json.NewDecoder(bytes.NewReader([]byte(tt.js)))
+			dec.UseNumber()
+			err = dec.Decode(&want)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotJSON, _ := json.Marshal(decoded)
+			wantJSON, _ := json.Marshal(want)
+
+			if !bytes.Equal(gotJSON, wantJSON) {
+				t.Errorf("got %s; want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestEncodeMsgPackKeepsKeyOrder(t *testing.T) {
+	tree, err := decodeOrdered([]byte(`{"b": 1, "a": [true, null]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = encodeMsgPack(&buf, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{0x82, 0xa1, 'b', 0x01, 0xa1, 'a', 0x92, 0xc3, 0xc0}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got % x; want % x", buf.Bytes(), want)
+	}
+}
+
+func TestIsList(t *testing.T) {
+	type row struct{ ID int64 }
+
+	tests := []struct {
+		name string
+		data envelope
+		want bool
+	}{
+		{name: "Slice of structs", data: envelope{"movies": []row{}, "metadata": struct{}{}}, want: true},
+		{name: "Slice of pointers", data: envelope{"movies": []*row{{ID: 1}}}, want: true},
+		{name: "Slice of maps", data: envelope{"facets": []map[string]int{}}, want: true},
+		{name: "Nil slice", data: envelope{"movies": []row(nil)}, want: false},
+		{name: "Slice of strings", data: envelope{"genres": []string{"drama"}}, want: false},
+		{name: "Single object", data: envelope{"movie": row{}}, want: false},
+		{name: "Two lists", data: envelope{"movies": []row{}, "duplicates": []row{}}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isList(tt.data); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
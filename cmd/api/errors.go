@@ -3,36 +3,192 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"slices"
+	"strings"
+
+	"greenlight.example.com/internal/data"
 )
 
 // the LogError() method is a generic helper for logging an error message along
 // with the current request method and URL as attributes in the log entry.
 func (app *application) logError(r *http.Request, err error) {
 	var (
-		method = r.Method
-		uri    = r.URL.RequestURI()
+		method    = r.Method
+		uri       = r.URL.RequestURI()
+		requestID = app.contextGetRequestID(r)
 	)
 
-	app.logger.Error(err.Error(), "method", method, "uri", uri)
+	app.logger.Error(err.Error(), "method", method, "uri", uri, "request_id", requestID)
+}
+
+// Define stable, machine-readable codes for each kind of error response, so that clients
+// can tell them apart without matching on the messages. They are sent in the "code" member
+// of problem details responses, which also have a type URI built from the code.
+const (
+	codeInternalError        = "internal_error"
+	codeNotFound             = "not_found"
+	codeMethodNotAllowed     = "method_not_allowed"
+	codeBadRequest           = "bad_request"
+	codeValidationFailed     = "validation_failed"
+	codeEditConflict         = "edit_conflict"
+	codeNotAcceptable        = "not_acceptable"
+	codeUnsupportedMediaType = "unsupported_media_type"
+	codePatchConflict        = "patch_conflict"
+	codePreconditionFailed   = "precondition_failed"
+	codePreconditionRequired = "precondition_required"
+	codeRateLimitExceeded    = "rate_limit_exceeded"
+	codeDuplicateMovie       = "duplicate_movie"
+	codeIdempotencyInFlight  = "idempotency_key_in_flight"
+	codeIdempotencyReused    = "idempotency_key_reused"
+	codeInvalidCredentials   = "invalid_credentials"
+	codeInvalidToken         = "invalid_authentication_token"
+	codeAuthRequired         = "authentication_required"
+	codeInactiveAccount      = "inactive_account"
+	codeNotPermitted         = "not_permitted"
+)
+
+// The problemTitles map holds the title for each error code, which is the same for every
+// occurrence of the error.
+var problemTitles = map[string]string{
+	codeInternalError:        "Internal server error",
+	codeNotFound:             "Resource not found",
+	codeMethodNotAllowed:     "Method not allowed",
+	codeBadRequest:           "Bad request",
+	codeValidationFailed:     "Validation failed",
+	codeEditConflict:         "Edit conflict",
+	codeNotAcceptable:        "Not acceptable",
+	codeUnsupportedMediaType: "Unsupported media type",
+	codePatchConflict:        "Patch conflict",
+	codePreconditionFailed:   "Precondition failed",
+	codePreconditionRequired: "Precondition required",
+	codeRateLimitExceeded:    "Rate limit exceeded",
+	codeDuplicateMovie:       "Duplicate movie",
+	codeIdempotencyInFlight:  "Idempotency key in use",
+	codeIdempotencyReused:    "Idempotency key reused",
+	codeInvalidCredentials:   "Invalid credentials",
+	codeInvalidToken:         "Invalid authentication token",
+	codeAuthRequired:         "Authentication required",
+	codeInactiveAccount:      "Inactive account",
+	codeNotPermitted:         "Not permitted",
 }
 
-// The errorResponse() method is a generic helper for sending JSON-formatted error
+// The problemTypeBase is the prefix of the type URIs for problem details responses.
+const problemTypeBase = "https://greenlight.example.com/problems/"
+
+// The errorResponse() method is a generic helper for sending formatted error
 // messages to the client with a given status code.
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
-	// Encapsulate the message in an 'error' envelope
-	env := envelope{"error": message}
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code string, message any) {
+	app.errorResponseWith(w, r, status, code, message, nil)
+}
+
+// The errorResponseWith() method is like errorResponse(), but also sends the given extra
+// members alongside the error.
+//
+// By default, errors are sent in an 'error' envelope as usual. If the client accepts
+// application/problem+json, or the problem-details config setting is on, then they are
+// sent in the RFC 9457 problem details format instead, with the error code and request ID.
+// In that format validation errors become an "errors" array, with a JSON Pointer to each
+// field in the request body, or the name of each query string parameter.
+func (app *application) errorResponseWith(w http.ResponseWriter, r *http.Request, status int, code string, message any, extensions envelope) {
+	if !app.useProblemDetails(r) {
+		// Encapsulate the message in an 'error' envelope
+		env := envelope{"error": message}
+		for key, value := range extensions {
+			env[key] = value
+		}
+
+		// Write a response using the writeResponse() helper. If this happens to return
+		// an error then log it, and fall back to sending the client an empty response
+		// with a 500 internal server error status code.
+		err := app.writeResponse(w, r, status, env, nil)
+		if err != nil {
+			app.logError(r, err)
+			w.WriteHeader(500)
+		}
+		return
+	}
+
+	env := envelope{
+		"type":     problemTypeBase + code,
+		"title":    problemTitles[code],
+		"status":   status,
+		"instance": r.URL.Path,
+		"code":     code,
+	}
+
+	if requestID := app.contextGetRequestID(r); requestID != "" {
+		env["request_id"] = requestID
+	}
+
+	switch message := message.(type) {
+	case string:
+		env["detail"] = message
+	case map[string]string:
+		env["detail"] = "the request contains invalid values, see errors for details"
+		env["errors"] = problemErrors(r, message)
+	}
+
+	for key, value := range extensions {
+		env[key] = value
+	}
 
-	// Write a response using the writeJSON() helper. If this happens to return an
-	// error then log it, and fall back to sending the client an empty response with a
-	// 500 internal server error status code.
+	// Problem details are always sent as JSON, and whether they are sent depends on the
+	// Accept header.
+	headers := make(http.Header)
+	headers.Set("Content-Type", "application/problem+json")
+	headers.Set("Vary", "Accept")
 
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeJSON(w, status, env, headers)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
 	}
 }
 
+// The useProblemDetails() method reports whether errors should be sent in the problem
+// details format for a request.
+func (app *application) useProblemDetails(r *http.Request) bool {
+	if app.config.errors.problemDetails {
+		return true
+	}
+
+	for _, mr := range parseAccept(r) {
+		if mr.mediaType == "application/problem+json" && mr.q > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// problemErrors() converts the errors from a validator into the "errors" array for a
+// problem details response, sorted by key. Keys which are query string parameters of the
+// request are given as a "parameter", and any others as a JSON "pointer" into the request
+// body.
+func problemErrors(r *http.Request, errors map[string]string) []envelope {
+	keys := make([]string, 0, len(errors))
+	for key := range errors {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	qs := r.URL.Query()
+	result := make([]envelope, len(keys))
+
+	for i, key := range keys {
+		if qs.Has(key) {
+			result[i] = envelope{"parameter": key, "detail": errors[key]}
+			continue
+		}
+
+		// Escape the key as a JSON Pointer reference token, as in RFC 6901.
+		token := strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+		result[i] = envelope{"pointer": "/" + token, "detail": errors[key]}
+	}
+
+	return result
+}
+
 // The serverErrorResponse() method will be used when out application encounters an
 // unexpected problem and runtime.  It logs the detailed error message, then uses the
 // errorResponse() helper to send a 500 Internal Server Error status code and JSON
@@ -42,37 +198,128 @@ func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Reque
 	app.logError(r, err)
 
 	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, codeInternalError, message)
 }
 
 // The notFoundResponse() method will be used to send a 404 Not Found status code and
 // JSON response to the client.
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, codeNotFound, message)
 }
 
 // Then methodNotAllowedResponse() method will be used to send a 405 Method Not Allowed
 // status code and JSON response to the client.
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, message)
 }
 
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	app.errorResponse(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
 }
 
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, codeValidationFailed, errors)
 }
 
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
+	app.errorResponse(w, r, http.StatusConflict, codeEditConflict, message)
+}
+
+// The notAcceptableResponse() method sends a 406 Not Acceptable status code when none of
+// the response formats are acceptable to the client.
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the requested media type is not available, use application/json, application/hal+json, application/xml, text/csv (for lists) or application/msgpack"
+	app.errorResponse(w, r, http.StatusNotAcceptable, codeNotAcceptable, message)
+}
+
+// The unsupportedMediaTypeResponse() method sends a 415 Unsupported Media Type status code
+// when the Content-Type of a PATCH request isn't one of the supported patch formats, along
+// with an Accept-Patch header listing them.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, mediaType string) {
+	w.Header().Set("Accept-Patch", acceptPatch)
+	message := fmt.Sprintf("the %s media type is not supported for this resource", mediaType)
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, message)
+}
+
+// The patchConflictResponse() method sends a 409 Conflict status code when a patch can't
+// be applied to the current state of the record, such as when a test operation fails.
+func (app *application) patchConflictResponse(w http.ResponseWriter, r *http.Request, err error) {
+	message := fmt.Sprintf("unable to apply the patch: %s", err.Error())
+	app.errorResponse(w, r, http.StatusConflict, codePatchConflict, message)
+}
+
+// The preconditionFailedResponse() method sends a 412 Precondition Failed status code when
+// the If-Match or X-Expected-Version header of a request doesn't match the current version
+// of the record.
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been modified since you fetched it, please fetch it again and retry"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, codePreconditionFailed, message)
+}
+
+// The preconditionRequiredResponse() method sends a 428 Precondition Required status code
+// when preconditions are required but a request has neither an If-Match nor an
+// X-Expected-Version header.
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this request must include an If-Match or X-Expected-Version header"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, codePreconditionRequired, message)
 }
 
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	app.errorResponse(w, r, http.StatusTooManyRequests, codeRateLimitExceeded, message)
+}
+
+// The idempotencyKeyInFlightResponse() method sends a 409 Conflict status code when a
+// request has the same Idempotency-Key as one which is still being processed.
+func (app *application) idempotencyKeyInFlightResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "1")
+	message := "a request with this Idempotency-Key is still being processed, please retry later"
+	app.errorResponse(w, r, http.StatusConflict, codeIdempotencyInFlight, message)
+}
+
+// The idempotencyKeyReusedResponse() method sends a 422 Unprocessable Entity status code
+// when an Idempotency-Key is reused for a request with a different method, URL or body.
+func (app *application) idempotencyKeyReusedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this Idempotency-Key was already used for a different request"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, codeIdempotencyReused, message)
+}
+
+// The duplicateMovieResponse() method sends a 409 Conflict status code along with the
+// existing movies which the new one appears to duplicate, so that the client can either
+// use one of them or retry with the allow_duplicate override.
+func (app *application) duplicateMovieResponse(w http.ResponseWriter, r *http.Request, duplicates []*data.SimilarMovie) {
+	message := "the movie appears to duplicate an existing record, resubmit with allow_duplicate=true to create it anyway"
+	app.errorResponseWith(w, r, http.StatusConflict, codeDuplicateMovie, message, envelope{"duplicates": duplicates})
+}
+
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, codeInvalidCredentials, message)
+}
+
+// The invalidAuthenticationTokenResponse() method sends a 401 Unauthorized status code,
+// with a WWW-Authenticate header to tell the client that a bearer token is expected.
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, codeInvalidToken, message)
+}
+
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, codeAuthRequired, message)
+}
+
+func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, codeInactiveAccount, message)
+}
+
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, codeNotPermitted, message)
 }
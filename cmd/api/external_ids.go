@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"greenlight.example.com/internal/data"
+	"greenlight.example.com/internal/validator"
+)
+
+// Retrieve the "source" and "id" url params for the by-external endpoints from the
+// current request context. Unlike readIDParam(), the id here is the partner's own
+// identifier and so is returned as a string.
+func (app *application) readExternalIDParams(r *http.Request) *data.ExternalID {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	return &data.ExternalID{
+		Source: params.ByName("source"),
+		ID:     params.ByName("id"),
+	}
+}
+
+// Add a showMovieByExternalIDHandler for the "GET /v1/movies/by-external/:source/:id"
+// endpoint.
+func (app *application) showMovieByExternalIDHandler(w http.ResponseWriter, r *http.Request) {
+	externalID := app.readExternalIDParams(r)
+
+	// An identifier that can't be valid for its source can't be mapped to a movie, so
+	// there's no need to query the database.
+	v := validator.New()
+
+	if data.ValidateExternalID(v, externalID); !v.Valid() {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.ExternalIDs.GetMovie(externalID.Source, externalID.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+
+	err = app.localizeMovies(r, headers, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a putMovieByExternalIDHandler for the "PUT /v1/movies/by-external/:source/:id"
+// endpoint. It creates the movie if the external identifier isn't known yet, or
+// replaces the mapped movie's fields otherwise, so partner feeds can be replayed safely.
+func (app *application) putMovieByExternalIDHandler(w http.ResponseWriter, r *http.Request) {
+	externalID := app.readExternalIDParams(r)
+
+	var input struct {
+		Title   string       `json:"title"`
+		Year    int32        `json:"year"`
+		Runtime data.Runtime `json:"runtime"`
+		Genres  []string     `json:"genres"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	movie := &data.Movie{
+		Title:   input.Title,
+		Year:    input.Year,
+		Runtime: input.Runtime,
+		Genres:  input.Genres,
+	}
+
+	v := validator.New()
+
+	data.ValidateExternalID(v, externalID)
+
+	// A movie dated in the future must have an upcoming release, which can only be the
+	// case if it's already mapped.
+	existing, err := app.models.ExternalIDs.GetMovie(externalID.Source, externalID.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if existing != nil {
+		movie.Upcoming = existing.Upcoming
+	}
+
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	created, err := app.models.ExternalIDs.UpsertMovie(externalID, movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+
+	// Send 201 Created the first time an identifier is seen, and 200 OK on every
+	// subsequent replay.
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	err = app.writeResponse(w, r, status, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
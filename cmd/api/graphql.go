@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/location"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"greenlight.example.com/internal/data"
+	"greenlight.example.com/internal/validator"
+)
+
+// Define the limits on the shape of GraphQL queries. The depth is the number of levels of
+// nested selections, and the complexity is an estimate of the number of fields which will
+// be resolved (see graphQLCost()).
+const (
+	graphQLMaxDepth      = 6
+	graphQLMaxComplexity = 2000
+)
+
+// The number of items which list fields are assumed to return when estimating the
+// complexity of a query. The root movies field uses its pageSize argument instead.
+var graphQLListSizes = map[string]int{
+	"collections": 10,
+}
+
+// A graphQLError is an error which is returned to the client in the errors array of a
+// GraphQL response, with a machine-readable code (and, for validation errors, the failed
+// checks) in its extensions.
+type graphQLError struct {
+	message string
+	code    string
+	errors  map[string]string
+}
+
+func (e graphQLError) Error() string {
+	return e.message
+}
+
+func (e graphQLError) Extensions() map[string]any {
+	extensions := map[string]any{"code": e.code}
+	if len(e.errors) > 0 {
+		extensions["errors"] = e.errors
+	}
+	return extensions
+}
+
+// Return a GraphQL error for input which failed validation.
+func graphQLValidationError(errors map[string]string) error {
+	return graphQLError{message: "the input failed validation", code: "BAD_USER_INPUT", errors: errors}
+}
+
+// Return a GraphQL error for a record which couldn't be found.
+func graphQLNotFoundError() error {
+	return graphQLError{message: "the requested resource could not be found", code: "NOT_FOUND"}
+}
+
+// Log an unexpected error and return a generic GraphQL error in its place, so that the
+// details aren't leaked to the client.
+func (app *application) graphQLServerError(r *http.Request, err error) error {
+	app.logError(r, err)
+	return graphQLError{message: "the server encountered a problem and could not process your request", code: "INTERNAL_SERVER_ERROR"}
+}
+
+// A graphQLRequest holds the state for a single GraphQL request, which resolvers get from
+// the context.
+type graphQLRequest struct {
+	r           *http.Request
+	collections *collectionLoader
+}
+
+type graphQLContextKey struct{}
+
+// Return the graphQLRequest from a resolver's context.
+func graphQLRequestFrom(ctx context.Context) *graphQLRequest {
+	return ctx.Value(graphQLContextKey{}).(*graphQLRequest)
+}
+
+// A collectionLoader batches the lookups of the collections which movies belong to, so
+// that fetching the collections for a list of movies takes one query rather than one per
+// movie. Each call to load() queues a movie ID and returns a thunk. The GraphQL executor
+// resolves all of the fields at one level before calling any of their thunks, so the first
+// thunk to be called loads the collections for every queued movie at once.
+type collectionLoader struct {
+	models  data.Models
+	pending []int64
+	results map[int64][]*data.MovieCollection
+}
+
+func (l *collectionLoader) load(movieID int64) func() (any, error) {
+	l.pending = append(l.pending, movieID)
+
+	return func() (any, error) {
+		if len(l.pending) > 0 {
+			results, err := l.models.Collections.GetAllForMovies(l.pending)
+			if err != nil {
+				return nil, err
+			}
+
+			for id, collections := range results {
+				l.results[id] = collections
+			}
+			l.pending = nil
+		}
+
+		collections, ok := l.results[movieID]
+		if !ok {
+			return []*data.MovieCollection{}, nil
+		}
+		return collections, nil
+	}
+}
+
+// Add a graphQLHandler for the "POST /v1/graphql" endpoint. The schema is built once,
+// when the routes are registered.
+func (app *application) graphQLHandler() http.HandlerFunc {
+	schema, err := app.newGraphQLSchema()
+	if err != nil {
+		panic(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Query         string         `json:"query"`
+			OperationName string         `json:"operationName"`
+			Variables     map[string]any `json:"variables"`
+		}
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		v := validator.New()
+
+		if v.Check(input.Query != "", "query", "must be provided"); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		result := app.executeGraphQL(r, schema, input.Query, input.OperationName, input.Variables)
+
+		// GraphQL responses aren't wrapped in an envelope of our own. Errors are reported
+		// in the errors array alongside any data, with a 200 OK status code.
+		env := envelope{}
+		if result.Data != nil {
+			env["data"] = result.Data
+		}
+		if len(result.Errors) > 0 {
+			env["errors"] = result.Errors
+		}
+
+		// Movies are localized according to the Accept-Language header, as in the REST
+		// endpoints.
+		headers := make(http.Header)
+		headers.Set("Vary", "Accept-Language")
+
+		err = app.writeJSON(w, http.StatusOK, env, headers)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// Parse and validate a GraphQL query, check it against the depth and complexity limits,
+// and then execute it.
+func (app *application) executeGraphQL(r *http.Request, schema graphql.Schema, query, operationName string, variables map[string]any) *graphql.Result {
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+
+	validation := graphql.ValidateDocument(&schema, doc, nil)
+	if !validation.IsValid {
+		return &graphql.Result{Errors: validation.Errors}
+	}
+
+	limitErr := checkGraphQLLimits(doc, variables)
+	if limitErr != nil {
+		return &graphql.Result{Errors: []gqlerrors.FormattedError{{
+			Message:    limitErr.message,
+			Locations:  []location.SourceLocation{},
+			Extensions: limitErr.Extensions(),
+		}}}
+	}
+
+	ctx := context.WithValue(r.Context(), graphQLContextKey{}, &graphQLRequest{
+		r:           r,
+		collections: &collectionLoader{models: app.models, results: make(map[int64][]*data.MovieCollection)},
+	})
+
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        schema,
+		AST:           doc,
+		OperationName: operationName,
+		Args:          variables,
+		Context:       ctx,
+	})
+}
+
+// Check each of the operations in a validated GraphQL document against the depth and
+// complexity limits, returning a graphQLError if any of them are exceeded.
+func checkGraphQLLimits(doc *ast.Document, variables map[string]any) *graphQLError {
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, definition := range doc.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	for _, definition := range doc.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+
+		cost, depth := graphQLCost(operation.SelectionSet, fragments, variables, 1)
+
+		if depth > graphQLMaxDepth {
+			return &graphQLError{
+				message: fmt.Sprintf("query has a depth of %d, which exceeds the maximum of %d", depth, graphQLMaxDepth),
+				code:    "QUERY_TOO_DEEP",
+			}
+		}
+		if cost > graphQLMaxComplexity {
+			return &graphQLError{
+				message: fmt.Sprintf("query has a complexity of %d, which exceeds the maximum of %d", cost, graphQLMaxComplexity),
+				code:    "QUERY_TOO_COMPLEX",
+			}
+		}
+	}
+
+	return nil
+}
+
+// Return the estimated cost and the depth of a selection set at the given depth. Each
+// field costs 1, plus the cost of its own selections multiplied by the number of items it
+// is expected to return if it is a list. Fragments are expanded in place; the document has
+// already been validated, so they can't form cycles.
+func graphQLCost(set *ast.SelectionSet, fragments map[string]*ast.FragmentDefinition, variables map[string]any, depth int) (cost, maxDepth int) {
+	if set == nil {
+		return 0, depth - 1
+	}
+
+	maxDepth = depth
+
+	for _, selection := range set.Selections {
+		var selectionCost, selectionDepth int
+
+		switch selection := selection.(type) {
+		case *ast.Field:
+			childCost, childDepth := graphQLCost(selection.SelectionSet, fragments, variables, depth+1)
+			selectionCost = 1 + childCost*graphQLListSize(selection, variables, depth)
+			selectionDepth = max(depth, childDepth)
+		case *ast.InlineFragment:
+			selectionCost, selectionDepth = graphQLCost(selection.SelectionSet, fragments, variables, depth)
+		case *ast.FragmentSpread:
+			if fragment, ok := fragments[selection.Name.Value]; ok {
+				selectionCost, selectionDepth = graphQLCost(fragment.SelectionSet, fragments, variables, depth)
+			}
+		}
+
+		cost += selectionCost
+		maxDepth = max(maxDepth, selectionDepth)
+	}
+
+	return cost, maxDepth
+}
+
+// Return the number of items which a field is expected to return, for estimating the
+// complexity of a query.
+func graphQLListSize(field *ast.Field, variables map[string]any, depth int) int {
+	if depth == 1 && field.Name.Value == "movies" {
+		pageSize := 20
+
+		for _, argument := range field.Arguments {
+			if argument.Name.Value != "pageSize" {
+				continue
+			}
+
+			switch value := argument.Value.(type) {
+			case *ast.IntValue:
+				if n, err := strconv.Atoi(value.Value); err == nil {
+					pageSize = n
+				}
+			case *ast.Variable:
+				if n, ok := variables[value.Name.Value].(float64); ok {
+					pageSize = int(n)
+				}
+			}
+		}
+
+		return max(pageSize, 1)
+	}
+
+	if size, ok := graphQLListSizes[field.Name.Value]; ok {
+		return size
+	}
+
+	return 1
+}
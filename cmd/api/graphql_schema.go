@@ -0,0 +1,521 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"greenlight.example.com/internal/data"
+	"greenlight.example.com/internal/validator"
+)
+
+// Build the GraphQL schema. The queries and mutations mirror the REST endpoints, and go
+// through the same models and validation.
+func (app *application) newGraphQLSchema() (graphql.Schema, error) {
+	metadataType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Metadata",
+		Fields: graphql.Fields{
+			"currentPage":  &graphql.Field{Type: graphql.Int},
+			"pageSize":     &graphql.Field{Type: graphql.Int},
+			"firstPage":    &graphql.Field{Type: graphql.Int},
+			"lastPage":     &graphql.Field{Type: graphql.Int},
+			"totalRecords": &graphql.Field{Type: graphql.Int},
+			"nextCursor":   &graphql.Field{Type: graphql.String},
+			"prevCursor":   &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	collectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "MovieCollection",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.ID),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return strconv.FormatInt(p.Source.(*data.MovieCollection).ID, 10), nil
+				},
+			},
+			"name":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"position": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+
+	movieType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Movie",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.ID),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return strconv.FormatInt(p.Source.(*data.Movie).ID, 10), nil
+				},
+			},
+			"title": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"year":  &graphql.Field{Type: graphql.Int},
+			"runtime": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Runtime in minutes",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return int(p.Source.(*data.Movie).Runtime), nil
+				},
+			},
+			"genres":   &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			"version":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"overview": &graphql.Field{Type: graphql.String},
+			"language": &graphql.Field{Type: graphql.String},
+			"collections": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(collectionType))),
+				// The collections are loaded in a batch for all of the movies being
+				// resolved, rather than with a query per movie.
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					req := graphQLRequestFrom(p.Context)
+					load := req.collections.load(p.Source.(*data.Movie).ID)
+
+					return func() (any, error) {
+						collections, err := load()
+						if err != nil {
+							return nil, app.graphQLServerError(req.r, err)
+						}
+						return collections, nil
+					}, nil
+				},
+			},
+		},
+	})
+
+	moviePageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "MoviePage",
+		Fields: graphql.Fields{
+			"movies":   &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(movieType)))},
+			"metadata": &graphql.Field{Type: graphql.NewNonNull(metadataType)},
+		},
+	})
+
+	userType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.ID),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return strconv.FormatInt(p.Source.(*data.User).ID, 10), nil
+				},
+			},
+			"name":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"email":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"activated": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		},
+	})
+
+	stringList := graphql.NewList(graphql.NewNonNull(graphql.String))
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"movie": &graphql.Field{
+				Type: movieType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: app.resolveMovie,
+			},
+			"movies": &graphql.Field{
+				Type: graphql.NewNonNull(moviePageType),
+				Args: graphql.FieldConfigArgument{
+					"title":          &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: ""},
+					"q":              &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "", Description: "Structured search query"},
+					"genres":         &graphql.ArgumentConfig{Type: stringList},
+					"genresAny":      &graphql.ArgumentConfig{Type: stringList},
+					"excludeGenres":  &graphql.ArgumentConfig{Type: stringList},
+					"tags":           &graphql.ArgumentConfig{Type: stringList},
+					"ids":            &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.ID))},
+					"collection":     &graphql.ArgumentConfig{Type: graphql.ID},
+					"yearMin":        &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+					"yearMax":        &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+					"runtimeMin":     &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+					"runtimeMax":     &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+					"upcoming":       &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+					"releasedAfter":  &graphql.ArgumentConfig{Type: graphql.String, Description: "YYYY-MM-DD"},
+					"releasedBefore": &graphql.ArgumentConfig{Type: graphql.String, Description: "YYYY-MM-DD"},
+					"page":           &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 1},
+					"pageSize":       &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 20},
+					"sort":           &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "id"},
+					"cursor":         &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: ""},
+				},
+				Resolve: app.resolveMovies,
+			},
+			"me": &graphql.Field{
+				Type:    userType,
+				Resolve: app.resolveMe,
+			},
+		},
+	})
+
+	mutationType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createMovie": &graphql.Field{
+				Type: movieType,
+				Args: graphql.FieldConfigArgument{
+					"title":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"year":           &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"runtime":        &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"genres":         &graphql.ArgumentConfig{Type: graphql.NewNonNull(stringList)},
+					"allowDuplicate": &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+				},
+				Resolve: app.resolveCreateMovie,
+			},
+			"updateMovie": &graphql.Field{
+				Type: movieType,
+				Args: graphql.FieldConfigArgument{
+					"id":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"title":   &graphql.ArgumentConfig{Type: graphql.String},
+					"year":    &graphql.ArgumentConfig{Type: graphql.Int},
+					"runtime": &graphql.ArgumentConfig{Type: graphql.Int},
+					"genres":  &graphql.ArgumentConfig{Type: stringList},
+					"expectedVersion": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "The version of the movie which the update is based on",
+					},
+				},
+				Resolve: app.resolveUpdateMovie,
+			},
+			"deleteMovie": &graphql.Field{
+				Type: graphql.ID,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"expectedVersion": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "The version of the movie which the deletion is based on",
+					},
+				},
+				Resolve: app.resolveDeleteMovie,
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:    queryType,
+		Mutation: mutationType,
+	})
+}
+
+// Resolve the movie query, which mirrors "GET /v1/movies/:id". Unlike the REST endpoint,
+// movies which were merged into another one aren't followed, and resolve to null.
+func (app *application) resolveMovie(p graphql.ResolveParams) (any, error) {
+	req := graphQLRequestFrom(p.Context)
+
+	id, err := strconv.ParseInt(p.Args["id"].(string), 10, 64)
+	if err != nil {
+		return nil, graphQLNotFoundError()
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, graphQLNotFoundError()
+		default:
+			return nil, app.graphQLServerError(req.r, err)
+		}
+	}
+
+	err = app.localizeMovies(req.r, make(http.Header), movie)
+	if err != nil {
+		return nil, app.graphQLServerError(req.r, err)
+	}
+
+	return movie, nil
+}
+
+// Resolve the movies query, which mirrors "GET /v1/movies" and accepts the same filters.
+func (app *application) resolveMovies(p graphql.ResolveParams) (any, error) {
+	req := graphQLRequestFrom(p.Context)
+
+	var input struct {
+		data.MovieFilters
+		data.Filters
+	}
+
+	v := validator.New()
+
+	input.Title = p.Args["title"].(string)
+	input.Query = app.readSearchQuery(p.Args["q"].(string), v)
+	input.Genres = graphQLStrings(p.Args["genres"])
+	input.GenresAny = graphQLStrings(p.Args["genresAny"])
+	input.ExcludeGenres = graphQLStrings(p.Args["excludeGenres"])
+	input.IDs = graphQLIDs(v, "ids", p.Args["ids"])
+
+	input.Tags = data.NormalizeTagFilter(graphQLStrings(p.Args["tags"]))
+
+	if collection, ok := p.Args["collection"]; ok {
+		ids := graphQLIDs(v, "collection", []any{collection})
+		if len(ids) == 1 {
+			input.CollectionID = ids[0]
+		}
+	}
+
+	input.YearMin = p.Args["yearMin"].(int)
+	input.YearMax = p.Args["yearMax"].(int)
+	input.RuntimeMin = p.Args["runtimeMin"].(int)
+	input.RuntimeMax = p.Args["runtimeMax"].(int)
+
+	input.Upcoming = p.Args["upcoming"].(bool)
+	input.ReleasedAfter = graphQLDate(v, "releasedAfter", p.Args["releasedAfter"])
+	input.ReleasedBefore = graphQLDate(v, "releasedBefore", p.Args["releasedBefore"])
+
+	input.Filters.Page = p.Args["page"].(int)
+	input.Filters.PageSize = p.Args["pageSize"].(int)
+	input.Filters.Sort = p.Args["sort"].(string)
+	input.Filters.SortSafelist = movieSortSafelist
+	input.Filters.Cursor = p.Args["cursor"].(string)
+	input.Filters.CursorsAllowed = true
+
+	v.Check(!slices.Contains(strings.Split(input.Filters.Sort, ","), "relevance") || input.Title != "", "sort", "must not include relevance without a title")
+
+	data.ValidateMovieFilters(v, input.MovieFilters)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		return nil, graphQLValidationError(v.Errors)
+	}
+
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieFilters, input.Filters, nil, false)
+	if err != nil {
+		return nil, app.graphQLServerError(req.r, err)
+	}
+
+	err = app.localizeMovies(req.r, make(http.Header), movies...)
+	if err != nil {
+		return nil, app.graphQLServerError(req.r, err)
+	}
+
+	return map[string]any{"movies": movies, "metadata": metadata}, nil
+}
+
+// Resolve the me query, which returns the user who the request was authenticated as by
+// the bearer token in its Authorization header.
+func (app *application) resolveMe(p graphql.ResolveParams) (any, error) {
+	req := graphQLRequestFrom(p.Context)
+
+	user := app.contextGetUser(req.r)
+	if user.IsAnonymous() {
+		return nil, graphQLError{message: "you must be authenticated to access this resource", code: "UNAUTHENTICATED"}
+	}
+
+	return user, nil
+}
+
+// Resolve the createMovie mutation, which mirrors "POST /v1/movies".
+func (app *application) resolveCreateMovie(p graphql.ResolveParams) (any, error) {
+	req := graphQLRequestFrom(p.Context)
+
+	movie := &data.Movie{
+		Title:   p.Args["title"].(string),
+		Year:    int32(p.Args["year"].(int)),
+		Runtime: data.Runtime(p.Args["runtime"].(int)),
+		Genres:  graphQLStrings(p.Args["genres"]),
+	}
+
+	v := validator.New()
+
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		return nil, graphQLValidationError(v.Errors)
+	}
+
+	if !p.Args["allowDuplicate"].(bool) {
+		duplicates, err := app.models.Movies.FindDuplicates(movie)
+		if err != nil {
+			return nil, app.graphQLServerError(req.r, err)
+		}
+
+		if len(duplicates) > 0 {
+			ids := make([]string, len(duplicates))
+			for i, duplicate := range duplicates {
+				ids[i] = strconv.FormatInt(duplicate.ID, 10)
+			}
+
+			return nil, graphQLError{
+				message: "a similar movie already exists",
+				code:    "DUPLICATE",
+				errors:  map[string]string{"duplicates": strings.Join(ids, ",")},
+			}
+		}
+	}
+
+	err := app.models.Movies.Insert(movie)
+	if err != nil {
+		return nil, app.graphQLServerError(req.r, err)
+	}
+
+	return movie, nil
+}
+
+// Resolve the updateMovie mutation, which mirrors "PATCH /v1/movies/:id". Arguments which
+// aren't provided leave the corresponding fields unchanged.
+func (app *application) resolveUpdateMovie(p graphql.ResolveParams) (any, error) {
+	req := graphQLRequestFrom(p.Context)
+
+	id, err := strconv.ParseInt(p.Args["id"].(string), 10, 64)
+	if err != nil {
+		return nil, graphQLNotFoundError()
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, graphQLNotFoundError()
+		default:
+			return nil, app.graphQLServerError(req.r, err)
+		}
+	}
+
+	err = app.checkGraphQLPrecondition(p, movie)
+	if err != nil {
+		return nil, err
+	}
+
+	if title, ok := p.Args["title"].(string); ok {
+		movie.Title = title
+	}
+	if year, ok := p.Args["year"].(int); ok {
+		movie.Year = int32(year)
+	}
+	if runtime, ok := p.Args["runtime"].(int); ok {
+		movie.Runtime = data.Runtime(runtime)
+	}
+	if genres, ok := p.Args["genres"]; ok {
+		movie.Genres = graphQLStrings(genres)
+	}
+
+	v := validator.New()
+
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		return nil, graphQLValidationError(v.Errors)
+	}
+
+	err = app.models.Movies.Update(movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return nil, graphQLError{message: "unable to update the record due to an edit conflict, please try again", code: "EDIT_CONFLICT"}
+		default:
+			return nil, app.graphQLServerError(req.r, err)
+		}
+	}
+
+	return movie, nil
+}
+
+// Resolve the deleteMovie mutation, which mirrors "DELETE /v1/movies/:id" and returns the
+// ID of the deleted movie.
+func (app *application) resolveDeleteMovie(p graphql.ResolveParams) (any, error) {
+	req := graphQLRequestFrom(p.Context)
+
+	id, err := strconv.ParseInt(p.Args["id"].(string), 10, 64)
+	if err != nil {
+		return nil, graphQLNotFoundError()
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, graphQLNotFoundError()
+		default:
+			return nil, app.graphQLServerError(req.r, err)
+		}
+	}
+
+	err = app.checkGraphQLPrecondition(p, movie)
+	if err != nil {
+		return nil, err
+	}
+
+	// Delete the movie, provided that it hasn't changed since it was checked.
+	err = app.models.Movies.DeleteVersion(movie.ID, movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return nil, graphQLError{message: "unable to delete the record due to an edit conflict, please try again", code: "EDIT_CONFLICT"}
+		default:
+			return nil, app.graphQLServerError(req.r, err)
+		}
+	}
+
+	return strconv.FormatInt(id, 10), nil
+}
+
+// The checkGraphQLPrecondition() method checks the expectedVersion argument of the
+// updateMovie and deleteMovie mutations against the current version of the movie, in the
+// same way as checkMoviePrecondition() checks the headers of the REST endpoints. If the
+// argument isn't given then the mutation goes ahead, unless preconditions are required by
+// the config.
+func (app *application) checkGraphQLPrecondition(p graphql.ResolveParams, movie *data.Movie) error {
+	expectedVersion, ok := p.Args["expectedVersion"].(int)
+	if !ok {
+		if app.config.preconditions.required {
+			return graphQLError{message: "this mutation must include an expectedVersion argument", code: "PRECONDITION_REQUIRED"}
+		}
+		return nil
+	}
+
+	if expectedVersion < 1 {
+		return graphQLValidationError(map[string]string{"expectedVersion": "must be a positive integer"})
+	}
+
+	if int32(expectedVersion) != movie.Version {
+		return graphQLError{message: "the record has been modified since you fetched it, please fetch it again and retry", code: "PRECONDITION_FAILED"}
+	}
+
+	return nil
+}
+
+// Convert a list of strings argument to a []string, which is empty if the argument wasn't
+// provided.
+func graphQLStrings(value any) []string {
+	values, _ := value.([]any)
+
+	s := make([]string, 0, len(values))
+	for _, value := range values {
+		s = append(s, value.(string))
+	}
+
+	return s
+}
+
+// Convert a list of IDs argument to a []int64, recording an error in the validator if any
+// of them aren't integers.
+func graphQLIDs(v *validator.Validator, key string, value any) []int64 {
+	values, _ := value.([]any)
+
+	ids := make([]int64, 0, len(values))
+	for _, value := range values {
+		id, err := strconv.ParseInt(value.(string), 10, 64)
+		if err != nil {
+			v.AddError(key, "must only contain integer values")
+			return []int64{}
+		}
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// Convert an optional "YYYY-MM-DD" string argument to a data.Date, recording an error in
+// the validator if it can't be parsed.
+func graphQLDate(v *validator.Validator, key string, value any) data.Date {
+	s, _ := value.(string)
+	if s == "" {
+		return data.Date{}
+	}
+
+	t, err := time.Parse(data.DateLayout, s)
+	if err != nil {
+		v.AddError(key, "must be a date in YYYY-MM-DD format")
+		return data.Date{}
+	}
+
+	return data.Date{Time: t}
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"greenlight.example.com/internal/data"
 	"greenlight.example.com/internal/validator"
 )
 
@@ -28,6 +32,25 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 // define an envelope type
 type envelope map[string]any
 
+// setHeaders() copies the given headers to the response. Most headers replace any which
+// were already set, but the Vary values are added to the existing ones (skipping any
+// which are already there), so that a handler can't drop the "Vary: Authorization" set by
+// the authenticate middleware and have shared caches serve one user's response to another.
+func setHeaders(w http.ResponseWriter, headers http.Header) {
+	for key, values := range headers {
+		if http.CanonicalHeaderKey(key) != "Vary" {
+			w.Header()[key] = values
+			continue
+		}
+
+		for _, value := range values {
+			if !slices.Contains(w.Header().Values("Vary"), value) {
+				w.Header().Add("Vary", value)
+			}
+		}
+	}
+}
+
 // writeJSON() takes the destination http.ResponseWriter, the HTTP statuscode to send, the
 // data to encode to JSON, and a header map containing any additional HTTP headers we want
 // to include in the response.
@@ -44,19 +67,141 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 
 	// At this point we know we wont encounter any more errors before writing the
 	// the response so we can add any headers that we want to include.
-	for key, value := range headers {
-		w.Header()[key] = value
-	}
+	setHeaders(w, headers)
 
-	// Add the "Content-Type: application/json" header, then write the status code and
-	// JSON response.
-	w.Header().Set("Content-Type", "application/json")
+	// Add the "Content-Type: application/json" header, unless a more specific JSON media
+	// type (like application/problem+json) was given in the headers, then write the
+	// status code and JSON response.
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
 	w.WriteHeader(status)
 	w.Write(js)
 
 	return nil
 }
 
+// pickFields() returns a value which encodes to the JSON encoding of src with only the
+// given keys, for sparse fieldsets. The src value must encode to either a JSON object or
+// an array of objects, and if no fields are given it is returned unchanged. Keys which are
+// omitted because they are empty are left out of the result as usual.
+//
+// The src value is only encoded once, when the result is encoded, and the keys which are
+// kept are copied across in the same order as in the full encoding.
+func pickFields(src any, fields []string) any {
+	if len(fields) == 0 {
+		return src
+	}
+
+	return sparseFieldset{src: src, fields: fields}
+}
+
+// A sparseFieldset is the value returned by pickFields().
+type sparseFieldset struct {
+	src    any
+	fields []string
+}
+
+// MarshalJSON() encodes the src value and then copies it to the result, leaving out the
+// keys of each object which aren't in the fieldset.
+func (s sparseFieldset) MarshalJSON() ([]byte, error) {
+	js, err := json.Marshal(s.src)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+
+	if len(js) > 0 && js[0] == '[' {
+		// Read the opening bracket, then each of the objects in turn.
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+
+		buf.WriteByte('[')
+		for i := 0; dec.More(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+
+			err = s.pickObject(dec, &buf)
+			if err != nil {
+				return nil, err
+			}
+		}
+		buf.WriteByte(']')
+
+		return buf.Bytes(), nil
+	}
+
+	err = s.pickObject(dec, &buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// pickObject() reads a JSON object from the decoder and writes the keys which are in
+// the fieldset to buf, copying their values as they are.
+func (s sparseFieldset) pickObject(dec *json.Decoder, buf *bytes.Buffer) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != json.Delim('{') {
+		return fmt.Errorf("sparse fieldset source must be an object, got %v", token)
+	}
+
+	buf.WriteByte('{')
+
+	picked := 0
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := token.(string)
+
+		var value json.RawMessage
+
+		err = dec.Decode(&value)
+		if err != nil {
+			return err
+		}
+
+		if !slices.Contains(s.fields, key) {
+			continue
+		}
+
+		if picked > 0 {
+			buf.WriteByte(',')
+		}
+		picked++
+
+		js, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+
+		buf.Write(js)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+
+	// Read the closing brace.
+	_, err = dec.Token()
+	if err != nil {
+		return err
+	}
+
+	buf.WriteByte('}')
+
+	return nil
+}
+
 // readJSON() limits the request body and attempts to decode the body contents into the
 // receiving destination struct, returning a variety of error types to help the client
 // understand if the decoding failed.
@@ -181,6 +326,29 @@ func (app *application) readCSV(qs url.Values, key string, defaultValue []string
 	return strings.Split(csv, ",")
 }
 
+// The readIntCSV() helper reads a comma-separated list of integers from the query string.
+// If any of the values could not be converted to an integer, then we record an error
+// message in the provided Validator instance and return the default value.
+func (app *application) readIntCSV(qs url.Values, key string, defaultValue []int64, v *validator.Validator) []int64 {
+	values := app.readCSV(qs, key, nil)
+	if values == nil {
+		return defaultValue
+	}
+
+	ints := make([]int64, len(values))
+
+	for i, value := range values {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			v.AddError(key, "must be a comma-separated list of integer values")
+			return defaultValue
+		}
+		ints[i] = n
+	}
+
+	return ints
+}
+
 // The readInt() helper reads a string value from the query string and converts it to an
 // integer before returning.  If the value could not be converted to an integer, then we
 // record an error message in the provided Validator instance.
@@ -202,3 +370,41 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	// Otherwise, return the converted integer value
 	return i
 }
+
+// The readBool() helper reads a string value from the query string and converts it to a
+// boolean before returning. If the value could not be converted, then we record an error
+// message in the provided Validator instance.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// The readDate() helper reads a "YYYY-MM-DD" string value from the query string and
+// converts it to a data.Date before returning. If the value could not be parsed, then we
+// record an error message in the provided Validator instance.
+func (app *application) readDate(qs url.Values, key string, defaultValue data.Date, v *validator.Validator) data.Date {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	t, err := time.Parse(data.DateLayout, s)
+	if err != nil {
+		v.AddError(key, "must be a date in YYYY-MM-DD format")
+		return defaultValue
+	}
+
+	return data.Date{Time: t}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSetHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing http.Header
+		headers  http.Header
+		want     http.Header
+	}{
+		{
+			name:     "Vary values are merged",
+			existing: http.Header{"Vary": {"Authorization"}},
+			headers:  http.Header{"Vary": {"Accept"}},
+			want:     http.Header{"Vary": {"Authorization", "Accept"}},
+		},
+		{
+			name:     "Repeated Vary values are skipped",
+			existing: http.Header{"Vary": {"Authorization", "Accept"}},
+			headers:  http.Header{"Vary": {"Accept", "Accept-Language"}},
+			want:     http.Header{"Vary": {"Authorization", "Accept", "Accept-Language"}},
+		},
+		{
+			name:     "Other headers are replaced",
+			existing: http.Header{"Vary": {"Authorization"}, "Content-Type": {"text/plain"}},
+			headers:  http.Header{"Content-Type": {"application/json"}, "Etag": {`"1-1"`}},
+			want:     http.Header{"Vary": {"Authorization"}, "Content-Type": {"application/json"}, "Etag": {`"1-1"`}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			for key, values := range tt.existing {
+				w.Header()[key] = values
+			}
+
+			setHeaders(w, tt.headers)
+
+			if !reflect.DeepEqual(w.Header(), tt.want) {
+				t.Errorf("got %v; want %v", w.Header(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"greenlight.example.com/internal/data"
+)
+
+// An idempotencyRecorder wraps a http.ResponseWriter, passing the response through while
+// keeping a copy of its status code, headers and body to be stored for replays.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = rec.ResponseWriter.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Middleware http.Handler which makes POST, PUT, PATCH and DELETE requests idempotent
+// when they have an Idempotency-Key header, so that clients can safely retry requests
+// which timed out. The first request with a key is carried out as usual, and its
+// response is stored with a fingerprint of the request (the method, URL and body). Later
+// requests with the same key are answered with the stored response, marked with an
+// Idempotent-Replayed header, rather than being carried out again. A 409 Conflict is sent
+// if the first request hasn't finished yet, and a 422 Unprocessable Entity if the key is
+// reused for a different request. Keys expire after the configured TTL.
+//
+// Keys are scoped to the authenticated user, so that clients can't collide with, replay
+// or block each other's keys, and anonymous requests with a key are rejected.
+//
+// Responses with a 5xx status code aren't stored, so that the request can be retried.
+func (app *application) idempotency(next http.Handler) http.Handler {
+	// Launch a background goroutine which deletes the expired keys once an hour. Expired
+	// keys are ignored anyway, so this just stops the table from growing.
+	go func() {
+		for {
+			time.Sleep(time.Hour)
+
+			_, err := app.models.Idempotency.DeleteExpired(app.config.idempotency.ttl)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		}
+	}()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			key = ""
+		}
+
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			app.errorResponse(w, r, http.StatusUnauthorized, codeAuthRequired, "you must be authenticated to use an Idempotency-Key")
+			return
+		}
+
+		if len(key) > 255 {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key header must not be more than 255 bytes long"))
+			return
+		}
+
+		// Read the body for the fingerprint, with the same limit as readJSON(), and then
+		// put it back for the handler.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			switch {
+			case errors.As(err, &maxBytesError):
+				app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit))
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+		hash.Write(body)
+		fingerprint := hash.Sum(nil)
+
+		existing, err := app.models.Idempotency.Reserve(user.ID, key, fingerprint, app.config.idempotency.ttl)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.idempotencyKeyInFlightResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case !bytes.Equal(existing.Fingerprint, fingerprint):
+				app.idempotencyKeyReusedResponse(w, r)
+			case existing.Status == 0:
+				app.idempotencyKeyInFlightResponse(w, r)
+			default:
+				setHeaders(w, existing.Headers)
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.Status)
+				w.Write(existing.Body)
+			}
+			return
+		}
+
+		// Release the key unless the response is stored below, so that the request can
+		// be retried if it fails. This also covers the handler panicking, in which case
+		// the panic carries on to the recoverPanic middleware.
+		completed := false
+		defer func() {
+			if !completed {
+				err := app.models.Idempotency.Release(user.ID, key)
+				if err != nil {
+					app.logError(r, err)
+				}
+			}
+		}()
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+			rec.header = w.Header().Clone()
+		}
+
+		if rec.status >= 500 {
+			return
+		}
+
+		// The request ID belongs to this request, so a replay sends its own instead.
+		rec.header.Del("X-Request-Id")
+
+		err = app.models.Idempotency.Complete(user.ID, key, rec.status, rec.header, rec.body.Bytes())
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+
+		completed = true
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"greenlight.example.com/internal/data"
+)
+
+// These cases are all decided before the key is looked up, so they don't need a database.
+func TestIdempotencyWithoutStore(t *testing.T) {
+	user := &data.User{ID: 1}
+
+	tests := []struct {
+		name       string
+		method     string
+		key        string
+		user       *data.User
+		wantStatus int
+		wantNext   bool
+	}{
+		{
+			name:       "No key",
+			method:     http.MethodPost,
+			user:       data.AnonymousUser,
+			wantStatus: http.StatusCreated,
+			wantNext:   true,
+		},
+		{
+			name:       "Key on a GET request is ignored",
+			method:     http.MethodGet,
+			key:        "abc",
+			user:       data.AnonymousUser,
+			wantStatus: http.StatusCreated,
+			wantNext:   true,
+		},
+		{
+			name:       "Anonymous request with a key",
+			method:     http.MethodPost,
+			key:        "abc",
+			user:       data.AnonymousUser,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "Key too long",
+			method:     http.MethodDelete,
+			key:        strings.Repeat("k", 256),
+			user:       user,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication()
+
+			called := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.WriteHeader(http.StatusCreated)
+			})
+
+			r := httptest.NewRequest(tt.method, "/v1/movies", strings.NewReader(`{}`))
+			if tt.key != "" {
+				r.Header.Set("Idempotency-Key", tt.key)
+			}
+			r = app.contextSetUser(r, tt.user)
+			w := httptest.NewRecorder()
+
+			app.idempotency(next).ServeHTTP(w, r)
+
+			if called != tt.wantNext {
+				t.Errorf("got next handler called %t; want %t", called, tt.wantNext)
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestIdempotencyRecorder(t *testing.T) {
+	w := httptest.NewRecorder()
+	rec := &idempotencyRecorder{ResponseWriter: w}
+
+	rec.Header().Set("Content-Type", "application/json")
+	rec.Write([]byte(`{"ok":`))
+	rec.Header().Set("X-Late", "ignored")
+	rec.WriteHeader(http.StatusTeapot)
+	rec.Write([]byte(`true}`))
+
+	if rec.status != http.StatusOK {
+		t.Errorf("got status %d; want %d", rec.status, http.StatusOK)
+	}
+	if got := rec.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("got Content-Type %q; want %q", got, "application/json")
+	}
+	if got := rec.header.Get("X-Late"); got != "" {
+		t.Errorf("got X-Late %q; want headers set after the first write left out", got)
+	}
+	if got := rec.body.String(); got != `{"ok":true}` {
+		t.Errorf("got body %q; want %q", got, `{"ok":true}`)
+	}
+	if got := w.Body.String(); got != `{"ok":true}` {
+		t.Errorf("got response body %q; want %q", got, `{"ok":true}`)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"greenlight.example.com/internal/data"
+	"greenlight.example.com/internal/validator"
+)
+
+// A link is a hypermedia link to another URL, with its relation type.
+type link struct {
+	rel  string
+	href string
+}
+
+// paginationLinks() returns the first, prev, next and last links for a page of a list,
+// as far as they exist. The links are built from the request URL, so they keep all of the
+// filter and sort parameters and only change the page or cursor. When the client is
+// paging with a cursor the prev and next links use the cursors from the metadata, and as
+// the total isn't known there is no last link.
+func paginationLinks(r *http.Request, metadata data.Metadata) []link {
+	pageURL := func(key, value string) string {
+		qs := r.URL.Query()
+		qs.Del("page")
+		qs.Del("cursor")
+		qs.Set(key, value)
+
+		return r.URL.Path + "?" + qs.Encode()
+	}
+
+	var links []link
+
+	if r.URL.Query().Get("cursor") != "" {
+		links = append(links, link{"first", pageURL("page", "1")})
+
+		if metadata.PrevCursor != "" {
+			links = append(links, link{"prev", pageURL("cursor", metadata.PrevCursor)})
+		}
+		if metadata.NextCursor != "" {
+			links = append(links, link{"next", pageURL("cursor", metadata.NextCursor)})
+		}
+
+		return links
+	}
+
+	// An empty list has no pages, and so no metadata.
+	if metadata.CurrentPage == 0 {
+		return nil
+	}
+
+	// Pages which would skip more than data.MaxOffset records can't be requested, so past
+	// that point the next link switches to the cursor from the metadata, if there is one,
+	// and there is no last link.
+	reachable := data.Filters{PageSize: metadata.PageSize}.PageReachable
+
+	links = append(links, link{"first", pageURL("page", strconv.Itoa(metadata.FirstPage))})
+
+	if metadata.CurrentPage > metadata.FirstPage {
+		links = append(links, link{"prev", pageURL("page", strconv.Itoa(metadata.CurrentPage-1))})
+	}
+	if metadata.CurrentPage < metadata.LastPage {
+		switch {
+		case reachable(metadata.CurrentPage + 1):
+			links = append(links, link{"next", pageURL("page", strconv.Itoa(metadata.CurrentPage+1))})
+		case metadata.NextCursor != "":
+			links = append(links, link{"next", pageURL("cursor", metadata.NextCursor)})
+		}
+	}
+
+	if reachable(metadata.LastPage) {
+		links = append(links, link{"last", pageURL("page", strconv.Itoa(metadata.LastPage))})
+	}
+
+	return links
+}
+
+// linkHeader() formats links as the value of an RFC 8288 Link header.
+func linkHeader(links []link) string {
+	values := make([]string, len(links))
+	for i, l := range links {
+		values[i] = fmt.Sprintf(`<%s>; rel="%s"`, l.href, l.rel)
+	}
+
+	return strings.Join(values, ", ")
+}
+
+// halLinks() converts links into a HAL _links object, which maps each relation type to
+// an object holding the href.
+func halLinks(links []link) envelope {
+	env := envelope{}
+	for _, l := range links {
+		env[l.rel] = envelope{"href": l.href}
+	}
+
+	return env
+}
+
+// A linkedMovie is a movie with HAL-style _links to itself and its related resources.
+type linkedMovie struct {
+	*data.Movie
+	Links envelope `json:"_links"`
+}
+
+// withMovieLinks() returns a linkedMovie for the movie.
+func withMovieLinks(movie *data.Movie) linkedMovie {
+	self := fmt.Sprintf("/v1/movies/%d", movie.ID)
+
+	return linkedMovie{
+		Movie: movie,
+		Links: halLinks([]link{
+			{"self", self},
+			{"similar", self + "/similar"},
+			{"releases", self + "/releases"},
+			{"tags", self + "/tags"},
+			{"translations", self + "/translations"},
+		}),
+	}
+}
+
+// The readLinks() method reports whether to include _links in the response, which they
+// are if the "links" query string parameter is true or the response is HAL.
+func (app *application) readLinks(r *http.Request, format responseFormat, v *validator.Validator) bool {
+	return app.readBool(r.URL.Query(), "links", false, v) || format.name == formatHAL.name
+}
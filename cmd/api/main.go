@@ -6,6 +6,7 @@ import (
 	"flag"
 	"log/slog"
 	"os"
+	"slices"
 	"time"
 
 	// Note that we alias the import to th blank identifier, to stop Go
@@ -28,18 +29,39 @@ type config struct {
 		maxIdleTime  time.Duration
 	}
 	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
+		rps               float64
+		burst             int
+		enabled           bool
+		autocompleteRPS   float64
+		autocompleteBurst int
+	}
+	search struct {
+		config string
+	}
+	stats struct {
+		cacheTTL time.Duration
+	}
+	preconditions struct {
+		required bool
+	}
+	errors struct {
+		problemDetails bool
+	}
+	idempotency struct {
+		ttl time.Duration
+	}
+	duplicates struct {
+		refreshInterval time.Duration
 	}
 }
 
 // Define and application struct to hold the dependencies for our HTTP handlers, helpers
 // and middleware.
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
+	config     config
+	logger     *slog.Logger
+	models     data.Models
+	statsCache *ttlCache
 }
 
 func main() {
@@ -64,12 +86,57 @@ func main() {
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
+	// The autocomplete endpoint is called on every keystroke, so it has its own, more
+	// generous, limits in place of the global ones.
+	flag.Float64Var(&cfg.limiter.autocompleteRPS, "limiter-autocomplete-rps", 10, "Rate limiter maximum autocomplete requests per second")
+	flag.IntVar(&cfg.limiter.autocompleteBurst, "limiter-autocomplete-burst", 20, "Rate limiter maximum autocomplete burst")
+
+	// Read the PostgreSQL text search configuration to use for title searches. Note that
+	// migration 000014 builds the title indexes for the "english" configuration, so if
+	// this is changed then the movies_title_idx and movie_translations_title_idx indexes
+	// must be rebuilt with the new configuration, or title searches will be slow. This is
+	// checked at startup.
+	flag.StringVar(&cfg.search.config, "search-config", "english", "PostgreSQL text search configuration for title searches (the title indexes must be rebuilt to match)")
+
+	// Read how long catalog statistics are cached for. A zero duration disables the cache.
+	flag.DurationVar(&cfg.stats.cacheTTL, "stats-cache-ttl", 30*time.Second, "Catalog statistics cache TTL (0 to disable)")
+
+	// Read whether PATCH and DELETE requests for movies must include an If-Match or
+	// X-Expected-Version header, and the GraphQL updateMovie and deleteMovie mutations an
+	// expectedVersion argument. This should be enabled in production, so that clients
+	// can't overwrite changes which they haven't seen.
+	flag.BoolVar(&cfg.preconditions.required, "preconditions-required", false, "Require If-Match, X-Expected-Version or expectedVersion on movie updates and deletes")
+
+	// Read whether errors are always sent as RFC 9457 problem details, rather than only
+	// when the client asks for them with an Accept header.
+	flag.BoolVar(&cfg.errors.problemDetails, "problem-details", false, "Always send errors as application/problem+json")
+
+	// Read how long Idempotency-Key values are remembered for, and so how long clients
+	// have to retry a request.
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "How long Idempotency-Key values are kept for")
+
+	// Read how often the suspected duplicate clusters are recomputed. This compares every
+	// title with every other one, so it is done in the background rather than on request.
+	flag.DurationVar(&cfg.duplicates.refreshInterval, "duplicates-refresh-interval", 10*time.Minute, "How often duplicate movie clusters are recomputed")
+
 	flag.Parse()
 
 	// Initialise a new structured logger which writes log entries to the standard out
 	// stream
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
+	// The text search configuration is interpolated into SQL queries, so check that it is
+	// one of the supported configurations before going any further.
+	if !slices.Contains(data.SearchConfigSafelist, cfg.search.config) {
+		logger.Error("invalid search-config value", "search_config", cfg.search.config)
+		os.Exit(1)
+	}
+
+	if cfg.duplicates.refreshInterval <= 0 {
+		logger.Error("duplicates-refresh-interval must be positive", "duplicates_refresh_interval", cfg.duplicates.refreshInterval)
+		os.Exit(1)
+	}
+
 	// Call the openDB() helper function to create a connection pool, passing in the
 	// config struct. If this returns an error, we log it and exit the application.
 	db, err := openDB(cfg)
@@ -87,10 +154,25 @@ func main() {
 
 	// Declare an instance of the application struct, containing the config struct and
 	// the logger.
+	models := data.NewModels(db)
+	models.Movies.SearchConfig = cfg.search.config
+
+	// Warn if the title indexes weren't built for the text search configuration, as
+	// Postgres won't use them for title searches.
+	ok, err := models.Movies.CheckSearchIndexes()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	if !ok {
+		logger.Warn("title search indexes don't match search-config, rebuild movies_title_idx and movie_translations_title_idx", "search_config", cfg.search.config)
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
+		config:     cfg,
+		logger:     logger,
+		models:     models,
+		statsCache: newTTLCache(cfg.stats.cacheTTL),
 	}
 
 	// Call aap.Serve() to start the server.
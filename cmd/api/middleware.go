@@ -1,13 +1,20 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
+	"greenlight.example.com/internal/data"
+	"greenlight.example.com/internal/validator"
 )
 
 // Middleware http.Handler to log and recover gracefully from any upstream application panics
@@ -27,8 +34,47 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-// Middleware http.Handler that implements a bucket token rate-limiter pattern.
+// requestIDRX matches the request IDs which are accepted from clients in the X-Request-Id
+// header.
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Middleware http.Handler which gives each request an ID, for matching up error responses
+// with the log entries for them. The ID is taken from the X-Request-Id header if the client
+// (or a proxy in front of us) sent a sensible one, and otherwise generated at random. It is
+// added to the request context and sent back in the X-Request-Id response header.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-Id")
+
+		if !requestIDRX.MatchString(requestID) {
+			b := make([]byte, 16)
+
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			requestID = hex.EncodeToString(b)
+		}
+
+		r = app.contextSetRequestID(r, requestID)
+		w.Header().Set("X-Request-Id", requestID)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Middleware http.Handler that implements a bucket token rate-limiter pattern, using the
+// global limits from the application config.
 func (app *application) rateLimit(next http.Handler) http.Handler {
+	return app.rateLimitWith(app.config.limiter.rps, app.config.limiter.burst, next)
+}
+
+// Middleware http.Handler that implements a bucket token rate-limiter pattern with the
+// given limits. Each call keeps its own map of clients, so endpoints wrapped separately
+// are limited independently of each other.
+func (app *application) rateLimitWith(rps float64, burst int, next http.Handler) http.Handler {
 	// Define a client struct to hold the rate limiter and last seen time for each client
 	type client struct {
 		limiter  *rate.Limiter
@@ -83,7 +129,7 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 			// initialise a new rate limiter and add the IP address and limiter to the map.
 			if _, found := clients[ip]; !found {
 				// Create a new client struct to the map if it doesn't exist.
-				clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)}
+				clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(rps), burst)}
 			}
 
 			// Update the last seen time for the client.
@@ -108,3 +154,88 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Middleware http.Handler which authenticates the request from the bearer token in its
+// Authorization header, if there is one, and adds the user to the request context. If
+// there isn't an Authorization header then the AnonymousUser is added instead, but an
+// invalid or expired token is always an error.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Add the "Vary: Authorization" header to the response. This indicates to any
+		// caches that the response may vary based on the value of the Authorization
+		// header in the request.
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+
+		if authorizationHeader == "" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Otherwise, we expect the value of the Authorization header to be in the format
+		// "Bearer <token>".
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		token := headerParts[1]
+
+		v := validator.New()
+
+		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requirePermission() middleware checks that the user is authenticated, that their
+// account is activated, and that they have the given permission, before calling the
+// next handler.
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+
+		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
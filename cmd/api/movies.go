@@ -4,6 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
 
 	"greenlight.example.com/internal/data"
 	"greenlight.example.com/internal/validator"
@@ -13,11 +17,19 @@ import (
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// Declare an anonyous struct to hold the information that we expect to be in the
 	// HTTP request body.  This struct will be our **target decode destination*.
+	// The releases are optional, but an announced movie which is dated in the future
+	// needs an upcoming release, so they can be given along with the movie.
 	var input struct {
-		Title   string       `json:"title"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
+		Title    string       `json:"title"`
+		Year     int32        `json:"year"`
+		Runtime  data.Runtime `json:"runtime"`
+		Genres   []string     `json:"genres"`
+		Releases []struct {
+			Country       string    `json:"country"`
+			Date          data.Date `json:"date"`
+			Type          string    `json:"type"`
+			Certification string    `json:"certification"`
+		} `json:"releases"`
 	}
 
 	// Use the new readJSON() helper to decode the request body into the input struct.
@@ -38,6 +50,31 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	// Initialise a new Validator instance
 	v := validator.New()
 
+	// Validate each of the releases, recording any errors against the index of the
+	// release, as in "releases[0].country".
+	var releases []*data.Release
+
+	for i, input := range input.Releases {
+		release := &data.Release{
+			Country:       strings.ToUpper(input.Country),
+			Date:          input.Date,
+			Type:          input.Type,
+			Certification: input.Certification,
+		}
+
+		rv := validator.New()
+		data.ValidateRelease(rv, release)
+		for key, message := range rv.Errors {
+			v.AddError(fmt.Sprintf("releases[%d].%s", i, key), message)
+		}
+
+		if release.Date.After(time.Now()) {
+			movie.Upcoming = true
+		}
+
+		releases = append(releases, release)
+	}
+
 	// Call the ValidateMovie() function and return a response containing the errors if
 	// any of the checks fail.
 	if data.ValidateMovie(v, movie); !v.Valid() {
@@ -45,11 +82,38 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Call the Insert() method on our movies model, passing in a pointer to the
-	// validated movie struct.
-	err = app.models.Movies.Insert(movie)
+	// Unless the client has explicitly asked to skip the check with the allow_duplicate
+	// query string parameter, look for existing movies with a similar title and year and
+	// refuse to create the movie if there are any.
+	if !app.readBool(r.URL.Query(), "allow_duplicate", false, v) {
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		duplicates, err := app.models.Movies.FindDuplicates(movie)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if len(duplicates) > 0 {
+			app.duplicateMovieResponse(w, r, duplicates)
+			return
+		}
+	}
+
+	// Call the InsertWithReleases() method on our movies model, passing in a pointer to
+	// the validated movie struct and its releases.
+	err = app.models.Movies.InsertWithReleases(movie, releases)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateRelease):
+			v.AddError("releases", "must not contain the same release more than once")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -60,9 +124,19 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
 
+	// Include the validators for the new movie, so that the client can make conditional
+	// requests for it straight away.
+	format, ok := negotiateFormat(r, false)
+	if !ok {
+		format = formatJSON
+	}
+
+	headers.Set("ETag", movieETag(movie, nil, format))
+	headers.Set("Last-Modified", movie.UpdatedAt.UTC().Format(http.TimeFormat))
+
 	// Write a JSON response with a 201 Created status code, the movie data in the
 	// response body, and the Location header.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -76,6 +150,27 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Check that the movie can be sent in a format which the client accepts before doing
+	// any more work. The format is also needed for the ETag.
+	format, ok := negotiateFormat(r, false)
+	if !ok {
+		app.notAcceptableResponse(w, r)
+		return
+	}
+
+	// Read the optional sparse fieldset, which limits the fields returned for the movie.
+	v := validator.New()
+
+	fields := app.readCSV(r.URL.Query(), "fields", []string{})
+
+	// Read whether to include HAL-style _links in the movie.
+	links := app.readLinks(r, format, v)
+
+	if data.ValidateFields(v, fields, data.MovieFieldSafelist); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	// Call the Get() method to fetch the data for a specific movie. We also need to
 	// use the errors.Is() function to check if it returns a data.ErrRecordNotFound
 	// error, in which case we send a 404 Not Found response to the client.
@@ -83,15 +178,90 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
+			app.movieNotFoundResponse(w, r, id)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	// Swap in the translation which best matches the client's Accept-Language header,
+	// if there is one.
+	headers := make(http.Header)
+
+	err = app.localizeMovies(r, headers, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Include the collections which the movie belongs to, unless they weren't asked for.
+	// They are loaded before the ETag is computed, as they are part of the representation.
+	if len(fields) == 0 || slices.Contains(fields, "collections") {
+		movie.Collections, err = app.models.Collections.GetAllForMovie(movie.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Send the ETag and Last-Modified validators, and if the client's cached copy is
+	// still current then send a 304 Not Modified response. The movie's updated_at time
+	// is bumped when its translations or collections change, as well as when the movie
+	// itself is updated. The _links are treated as one more field, so that the ETag
+	// changes when they are included.
+	etagFields := fields
+	if links {
+		etagFields = append(slices.Clone(fields), "_links")
+	}
+
+	headers.Set("ETag", movieETag(movie, etagFields, format))
+	headers.Set("Last-Modified", movie.UpdatedAt.UTC().Format(http.TimeFormat))
+	headers.Add("Vary", "Accept")
+
+	if notModified(r, headers.Get("ETag"), movie.UpdatedAt) {
+		app.notModifiedResponse(w, headers)
+		return
+	}
+
+	// Add the _links if they were asked for, and then trim the movie down to the
+	// requested fields, if any. The _links are kept when trimming.
+	var src any = movie
+	if links {
+		src = withMovieLinks(movie)
+		if len(fields) > 0 {
+			fields = append(fields, "_links")
+		}
+	}
+
+	picked := pickFields(src, fields)
+
 	// Encode the struct to JSON and send it as the HTTP response
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": picked}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The movieNotFoundResponse() method is used when a movie can't be found by ID. If the
+// movie was merged into another one, then it sends a 301 Moved Permanently response
+// pointing to the surviving movie. Otherwise it sends a regular 404 Not Found response.
+func (app *application) movieNotFoundResponse(w http.ResponseWriter, r *http.Request, id int64) {
+	survivorID, err := app.models.Movies.GetRedirect(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", survivorID))
+
+	err = app.writeResponse(w, r, http.StatusMovedPermanently, envelope{"message": "movie has been merged into another record"}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -119,42 +289,79 @@ func (app *application) UpdateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Declare an input struct to hold the expected data from the client. Use pointers for
-	// the Year, Title and Runtime fields so we can support a 'partial update' as they will
-	// retunrn 'Nil' when no value is entered.
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+	// Check the If-Match or X-Expected-Version header, if there is one, so that a client
+	// which fetched an older version of the movie can't overwrite the newer changes.
+	if !app.checkMoviePrecondition(w, r, movie) {
+		return
 	}
 
-	// Read the JSON request body data into the input struct. Decode as normal with pointers
-	err = app.readJSON(w, r, &input)
+	// The request body can be a plain JSON partial update, a JSON Merge Patch or a JSON
+	// Patch, depending on its Content-Type.
+	mediaType, err := readMediaType(r)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	// If the input.Title value is nil then we know that no corresponding "title" key/
-	// value pair was provided in the JSON request body. So we move on and leave the
-	// movie record unchanged. Otherwise, we update the movie record with the new title
-	// value. Importantly, because input.Title is a now a pointer to a string, we need
-	// to dereference the pointer using the * operator to get the underlying value
-	// before assigning it to our movie record.
-	if input.Title != nil {
-		movie.Title = *input.Title
-	}
+	switch mediaType {
+	case mediaTypeJSON:
+		// Declare an input struct to hold the expected data from the client. Use pointers
+		// for the Year, Title and Runtime fields so we can support a 'partial update' as
+		// they will retunrn 'Nil' when no value is entered.
+		var input struct {
+			Title   *string       `json:"title"`
+			Year    *int32        `json:"year"`
+			Runtime *data.Runtime `json:"runtime"`
+			Genres  []string      `json:"genres"`
+		}
 
-	// We also do the same for the other fields in the input struct.
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
-	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres // Note that we don't need to dereference a slice.
+		// Read the JSON request body data into the input struct. Decode as normal with
+		// pointers
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		// If the input.Title value is nil then we know that no corresponding "title" key/
+		// value pair was provided in the JSON request body. So we move on and leave the
+		// movie record unchanged. Otherwise, we update the movie record with the new title
+		// value. Importantly, because input.Title is a now a pointer to a string, we need
+		// to dereference the pointer using the * operator to get the underlying value
+		// before assigning it to our movie record.
+		if input.Title != nil {
+			movie.Title = *input.Title
+		}
+
+		// We also do the same for the other fields in the input struct.
+		if input.Year != nil {
+			movie.Year = *input.Year
+		}
+		if input.Runtime != nil {
+			movie.Runtime = *input.Runtime
+		}
+		if input.Genres != nil {
+			movie.Genres = input.Genres // Note that we don't need to dereference a slice.
+		}
+
+	case mediaTypeMergePatch, mediaTypeJSONPatch:
+		// Apply the patch to the movie, sending a 409 Conflict response if it can't be
+		// applied to the current version.
+		err = app.applyMoviePatch(w, r, mediaType, movie)
+		if err != nil {
+			var conflictErr patchConflictError
+			switch {
+			case errors.As(err, &conflictErr):
+				app.patchConflictResponse(w, r, err)
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+
+	default:
+		app.unsupportedMediaTypeResponse(w, r, mediaType)
+		return
 	}
 
 	// Validate the updated movie record, sending the client a 422 Unprocessable Entity
@@ -178,8 +385,18 @@ func (app *application) UpdateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Include the new validators for the movie.
+	format, ok := negotiateFormat(r, false)
+	if !ok {
+		format = formatJSON
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie, nil, format))
+	headers.Set("Last-Modified", movie.UpdatedAt.UTC().Format(http.TimeFormat))
+
 	// Write the updated movies record in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -194,9 +411,9 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Delete the movie from the database, sending a 404 Not Found response if the
-	// movie is not found.
-	err = app.models.Movies.Delete(id)
+	// Fetch the movie, sending a 404 Not Found response if the movie is not found, and
+	// check its version against the If-Match or X-Expected-Version header.
+	movie, err := app.models.Movies.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -207,32 +424,61 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if !app.checkMoviePrecondition(w, r, movie) {
+		return
+	}
+
+	// Delete the movie from the database, provided that it hasn't changed since it was
+	// checked.
+	err = app.models.Movies.DeleteVersion(movie.ID, movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	// Return a 200 OK status code along with a success message.
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// The movieSortSafelist holds the supported sort values for lists of movies. The relevance
+// sort always puts the best matches for the title first, so it has no "-" variant.
+var movieSortSafelist = []string{"id", "title", "year", "runtime", "relevance", "-id", "-title", "-year", "-runtime"}
+
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	// To keep things consistent with our other handlers, we'll define an input struct
 	// to hold the expected values from the request query string.
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieFilters
 		data.Filters
+		Facets    []string
+		Fields    []string
+		Highlight bool
+		Links     bool
 	}
+	// Check that the list can be sent in a format which the client accepts before doing
+	// any more work. The format is also needed for the ETag.
+	format, ok := negotiateFormat(r, true)
+	if !ok {
+		app.notAcceptableResponse(w, r)
+		return
+	}
+
 	// Initialise a new Validator instance
 	v := validator.New()
 
 	// Call r.URL.Query() to get the url.Values map containing the query string data.
 	qs := r.URL.Query()
 
-	// Use our helpers to extract the title and genres query string value, falling back
-	// to defaults of an empty string and an empty slice respectively if they are not
-	// provided by the client.
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	// Read the filters which are shared with the stats endpoint.
+	input.MovieFilters = app.readMovieFilters(qs, v)
 
 	// Get the page and page-size values as integers. We set the default page to 1 and
 	// page_size to 20.
@@ -240,14 +486,40 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 
 	// Extract the sort query string value, falling back to 'id' if it is not provided
-	// by the client(which will imply a ascending sort on movie ID).
+	// by the client(which will imply a ascending sort on movie ID). Several keys can be
+	// given, separated by commas, such as "-year,title".
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 
 	// Add the supported sort values for this endpoint to the sort safelist.
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafelist = movieSortSafelist
+
+	// Read the optional keyset pagination cursor. Each response includes next_cursor
+	// and prev_cursor values in its metadata which can be passed back here in place of
+	// a page number.
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.CursorsAllowed = true
+
+	// Read the optional list of facets to count the matching movies by.
+	input.Facets = app.readCSV(qs, "facets", []string{})
+
+	// Read the optional sparse fieldset, which limits the fields returned for each movie.
+	input.Fields = app.readCSV(qs, "fields", []string{})
+
+	// Read whether to highlight the words in each title which matched the title filter.
+	input.Highlight = app.readBool(qs, "highlight", false, v)
+
+	// Read whether to include HAL-style _links in the list and in each movie.
+	input.Links = app.readLinks(r, format, v)
+
+	// Relevance is relative to the title filter, so it can't be sorted by without one.
+	v.Check(!slices.Contains(strings.Split(input.Filters.Sort, ","), "relevance") || input.Title != "", "sort", "must not include relevance without a title")
+
+	// Execute the validation checks on the MovieFilters and Filters structs and send a
+	// response containing the errors if necessary.
+	data.ValidateMovieFilters(v, input.MovieFilters)
+	data.ValidateFacets(v, input.Facets)
+	data.ValidateFields(v, input.Fields, data.MovieListFieldSafelist)
 
-	// Execute the validation checks on the Filters struct and send a response
-	// containing the errors if necessary.
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -255,15 +527,292 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 
 	// Call the GetAll() method to retrieve the movies, passing in the various filter
 	// parameters
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieFilters, input.Filters, input.Fields, input.Highlight)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	env := envelope{"metadata": metadata}
+
+	// If any facets were requested, count the movies matching the same filters by each
+	// of them and include the counts in the response.
+	if len(input.Facets) > 0 {
+		facets, err := app.models.Movies.GetFacets(input.MovieFilters, input.Facets)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["facets"] = facets
+	}
+
+	// Localize the titles on this page according to the Accept-Language header.
+	headers := make(http.Header)
+
+	err = app.localizeMovies(r, headers, movies...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The highlights were made from the original titles, so redo them for any titles
+	// which were swapped for a translation.
+	if input.Highlight {
+		err = app.highlightLocalizedTitles(input.MovieFilters, movies)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Link to the other pages of the list, keeping the same filters, in a Link header.
+	pages := paginationLinks(r, metadata)
+	if len(pages) > 0 {
+		headers.Set("Link", linkHeader(pages))
+	}
+
+	// Add the _links if they were asked for, and then trim the movies down to the
+	// requested fields, if any. The _links are kept when trimming.
+	var src any = movies
+	if input.Links {
+		linked := make([]linkedMovie, len(movies))
+		for i, movie := range movies {
+			linked[i] = withMovieLinks(movie)
+		}
+		src = linked
+
+		if len(input.Fields) > 0 {
+			input.Fields = append(input.Fields, "_links")
+		}
+
+		env["_links"] = halLinks(append([]link{{"self", r.URL.RequestURI()}}, pages...))
+	}
+
+	env["movies"] = pickFields(src, input.Fields)
+
+	// The list has no version number of its own, so send a weak ETag computed from the
+	// response body. This still saves the client from downloading the list again if
+	// nothing on the page has changed.
+	etag, err := weakETag(env, format)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers.Set("ETag", etag)
+	headers.Add("Vary", "Accept")
+
+	if notModified(r, etag, time.Time{}) {
+		app.notModifiedResponse(w, headers)
+		return
+	}
+
 	// Send a JSON response containing the movie data.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a listSimilarMoviesHandler for the "GET /v1/movies/:id/similar" endpoint, which
+// returns a page of other movies ranked by how similar they are to the given one.
+func (app *application) listSimilarMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// Similar movies are always ordered by descending score, so this is the only sort
+	// value we support.
+	input.Filters.Sort = app.readString(qs, "sort", "-score")
+	input.Filters.SortSafelist = []string{"-score"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	similar, metadata, err := app.models.Movies.GetSimilar(movie, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movies := make([]*data.Movie, len(similar))
+	for i := range similar {
+		movies[i] = similar[i].Movie
+	}
+
+	headers := make(http.Header)
+
+	err = app.localizeMovies(r, headers, movies...)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movies": similar, "metadata": metadata}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The highlightLocalizedTitles() method replaces the highlight of each movie which has had
+// a translation applied with a highlight of its translated title.
+func (app *application) highlightLocalizedTitles(filters data.MovieFilters, movies []*data.Movie) error {
+	var localized []*data.Movie
+	var titles []string
+
+	for _, movie := range movies {
+		if movie.Language != "" {
+			localized = append(localized, movie)
+			titles = append(titles, movie.Title)
+		}
+	}
+
+	highlights, err := app.models.Movies.HighlightTitles(filters, titles)
+	if err != nil {
+		return err
+	}
+
+	for i, movie := range localized {
+		movie.Highlight = highlights[i]
 	}
+
+	return nil
+}
+
+// Add a listDuplicateMoviesHandler for the "GET /v1/movies/duplicates" endpoint, which
+// returns a page of clusters of movies that look like duplicates of each other. The
+// clusters are recomputed in the background by refreshDuplicateClusters(), so they can
+// lag behind recent changes to the movies by up to the refresh interval.
+func (app *application) listDuplicateMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// Clusters are always ordered by their lowest movie ID.
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	clusters, metadata, err := app.models.Movies.GetDuplicateClusters(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"clusters": clusters, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The refreshDuplicateClusters() method recomputes the duplicate movie clusters straight
+// away, and then again after every refresh interval. It never returns, so it should be
+// run in a background goroutine.
+func (app *application) refreshDuplicateClusters() {
+	for {
+		err := app.models.Movies.RefreshDuplicateClusters()
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+
+		time.Sleep(app.config.duplicates.refreshInterval)
+	}
+}
+
+// The readMovieFilters() helper reads the optional filters for lists of movies from the
+// query string, recording any errors in the provided Validator instance. It is shared by
+// the endpoints which accept the same filters as listMoviesHandler.
+func (app *application) readMovieFilters(qs url.Values, v *validator.Validator) data.MovieFilters {
+	var filters data.MovieFilters
+
+	// Use our helpers to extract the title and genres query string value, falling back
+	// to defaults of an empty string and an empty slice respectively if they are not
+	// provided by the client.
+	filters.Title = app.readString(qs, "title", "")
+	filters.Genres = app.readCSV(qs, "genres", []string{})
+	filters.GenresAny = app.readCSV(qs, "genres_any", []string{})
+	filters.ExcludeGenres = app.readCSV(qs, "exclude_genres", []string{})
+	filters.IDs = app.readIntCSV(qs, "ids", []int64{}, v)
+
+	// Read the optional range filters, where 0 means the bound isn't set.
+	filters.YearMin = app.readInt(qs, "year_min", 0, v)
+	filters.YearMax = app.readInt(qs, "year_max", 0, v)
+	filters.RuntimeMin = app.readInt(qs, "runtime_min", 0, v)
+	filters.RuntimeMax = app.readInt(qs, "runtime_max", 0, v)
+
+	// Tags are normalized in the same way as when they are attached to a movie, so that
+	// the filter isn't sensitive to case or spacing.
+	filters.Tags = data.NormalizeTagFilter(app.readCSV(qs, "tags", []string{}))
+
+	// Read the optional collection ID, where 0 means no collection filter.
+	filters.CollectionID = int64(app.readInt(qs, "collection", 0, v))
+
+	// Read the optional release filters.
+	filters.Upcoming = app.readBool(qs, "upcoming", false, v)
+	filters.ReleasedAfter = app.readDate(qs, "released_after", data.Date{}, v)
+	filters.ReleasedBefore = app.readDate(qs, "released_before", data.Date{}, v)
+
+	// Read and parse the optional structured search query, such as
+	// `year:>=2000 genre:drama "dark knight"`.
+	filters.Query = app.readSearchQuery(qs.Get("q"), v)
+
+	return filters
+}
+
+// The readSearchQuery() helper parses a structured search query, returning nil if there
+// isn't one. If it can't be parsed, then we record the error (which includes the position
+// of the problem) in the provided Validator instance.
+func (app *application) readSearchQuery(q string, v *validator.Validator) *data.SearchQuery {
+	if q == "" {
+		return nil
+	}
+
+	if len(q) > 500 {
+		v.AddError("q", "must not be more than 500 bytes long")
+		return nil
+	}
+
+	query, err := data.ParseSearchQuery(q)
+	if err != nil {
+		v.AddError("q", err.Error())
+		return nil
+	}
+
+	return query
 }
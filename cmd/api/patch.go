@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"greenlight.example.com/internal/data"
+)
+
+// The media types which can be used for the body of a PATCH request for a movie. Plain
+// JSON is a partial update, where the keys which are present replace the existing values.
+const (
+	mediaTypeJSON       = "application/json"
+	mediaTypeMergePatch = "application/merge-patch+json"
+	mediaTypeJSONPatch  = "application/json-patch+json"
+)
+
+// The acceptPatch value lists the supported media types for the Accept-Patch header.
+var acceptPatch = strings.Join([]string{mediaTypeJSON, mediaTypeMergePatch, mediaTypeJSONPatch}, ", ")
+
+// A patchConflictError is returned when a patch can't be applied to the current state of
+// a movie, such as when a test operation fails or a path doesn't exist.
+type patchConflictError struct {
+	err error
+}
+
+func (e patchConflictError) Error() string {
+	return e.err.Error()
+}
+
+func (e patchConflictError) Unwrap() error {
+	return e.err
+}
+
+// The moviePatchDocument struct holds the fields of a movie which can be changed by a
+// patch. Patches are applied to its JSON encoding, so they can't touch the ID or version.
+type moviePatchDocument struct {
+	Title   string       `json:"title,omitempty"`
+	Year    int32        `json:"year,omitempty"`
+	Runtime data.Runtime `json:"runtime,omitempty"`
+	Genres  []string     `json:"genres,omitempty"`
+}
+
+// readMediaType() returns the media type from the Content-Type header of a request,
+// without any parameters, defaulting to application/json if the header is missing.
+func readMediaType(r *http.Request) (string, error) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return mediaTypeJSON, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("Content-Type header is invalid: %w", err)
+	}
+
+	return mediaType, nil
+}
+
+// The applyMoviePatch() method reads a JSON Merge Patch (RFC 7396) or a JSON Patch
+// (RFC 6902) from the request body, depending on the media type, and applies it to the
+// movie. Unlike a plain JSON partial update, a merge patch can remove a field by setting
+// it to null, and a JSON Patch can change a single element of the genres array, such as by
+// adding to "/genres/-". The movie isn't validated here, so fields which are removed are
+// left empty for ValidateMovie() to catch.
+//
+// A patchConflictError is returned if the patch can't be applied to the movie, and any
+// other error means that the request body was invalid.
+func (app *application) applyMoviePatch(w http.ResponseWriter, r *http.Request, mediaType string, movie *data.Movie) error {
+	doc, err := json.Marshal(moviePatchDocument{
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
+	})
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+
+	// Use readJSON() to read the patch itself, so that it gets the same size limit and
+	// error messages as any other request body.
+	switch mediaType {
+	case mediaTypeMergePatch:
+		var patch json.RawMessage
+
+		err = app.readJSON(w, r, &patch)
+		if err != nil {
+			return err
+		}
+
+		// A merge patch for a movie must be an object. Any other value would replace the
+		// whole document.
+		if !bytes.HasPrefix(bytes.TrimSpace(patch), []byte("{")) {
+			return errors.New("body must be a JSON object")
+		}
+
+		patched, err = jsonpatch.MergePatch(doc, patch)
+		if err != nil {
+			return patchConflictError{err}
+		}
+
+	case mediaTypeJSONPatch:
+		var patch jsonpatch.Patch
+
+		err = app.readJSON(w, r, &patch)
+		if err != nil {
+			return err
+		}
+
+		for i, operation := range patch {
+			switch operation.Kind() {
+			case "add", "remove", "replace", "move", "copy", "test":
+			default:
+				return fmt.Errorf("body contains an unsupported operation %q (at index %d)", operation.Kind(), i)
+			}
+		}
+
+		patched, err = patch.Apply(doc)
+		if err != nil {
+			return patchConflictError{err}
+		}
+
+	default:
+		panic("unsupported patch media type: " + mediaType)
+	}
+
+	// Decode the patched document back into the movie. Unknown keys aren't allowed, so a
+	// patch can't add fields which don't exist.
+	var result moviePatchDocument
+
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&result)
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+
+		switch {
+		case errors.As(err, &unmarshalTypeError):
+			return fmt.Errorf("patch results in incorrect JSON type for field %q", unmarshalTypeError.Field)
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			return fmt.Errorf("patch results in unknown key %s", fieldName)
+		case errors.Is(err, data.ErrInvalidRuntimeFormat):
+			return fmt.Errorf("patch results in invalid runtime: %w", err)
+		default:
+			return fmt.Errorf("patch results in an invalid movie: %w", err)
+		}
+	}
+
+	movie.Title = result.Title
+	movie.Year = result.Year
+	movie.Runtime = result.Runtime
+	movie.Genres = result.Genres
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"greenlight.example.com/internal/data"
+)
+
+func TestApplyMoviePatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		mediaType    string
+		body         string
+		want         data.Movie
+		wantConflict bool
+		wantErr      string
+	}{
+		{
+			name:      "Merge patch replaces fields",
+			mediaType: mediaTypeMergePatch,
+			body:      `{"title": "Heat (1995)", "runtime": "171 mins"}`,
+			want:      data.Movie{Title: "Heat (1995)", Year: 1995, Runtime: 171, Genres: []string{"crime", "drama"}},
+		},
+		{
+			name:      "Merge patch removes fields with null",
+			mediaType: mediaTypeMergePatch,
+			body:      `{"year": null, "genres": null}`,
+			want:      data.Movie{Title: "Heat", Runtime: 170},
+		},
+		{
+			name:      "Merge patch must be an object",
+			mediaType: mediaTypeMergePatch,
+			body:      `["title"]`,
+			wantErr:   "body must be a JSON object",
+		},
+		{
+			name:      "Merge patch can't add fields",
+			mediaType: mediaTypeMergePatch,
+			body:      `{"version": 9}`,
+			wantErr:   `patch results in unknown key "version"`,
+		},
+		{
+			name:      "Merge patch with an invalid runtime",
+			mediaType: mediaTypeMergePatch,
+			body:      `{"runtime": "171 minutes"}`,
+			wantErr:   "patch results in invalid runtime",
+		},
+		{
+			name:      "JSON Patch appends a genre",
+			mediaType: mediaTypeJSONPatch,
+			body:      `[{"op": "add", "path": "/genres/-", "value": "thriller"}]`,
+			want:      data.Movie{Title: "Heat", Year: 1995, Runtime: 170, Genres: []string{"crime", "drama", "thriller"}},
+		},
+		{
+			name:      "JSON Patch with a passing test",
+			mediaType: mediaTypeJSONPatch,
+			body:      `[{"op": "test", "path": "/year", "value": 1995}, {"op": "replace", "path": "/year", "value": 1996}]`,
+			want:      data.Movie{Title: "Heat", Year: 1996, Runtime: 170, Genres: []string{"crime", "drama"}},
+		},
+		{
+			name:         "JSON Patch with a failing test",
+			mediaType:    mediaTypeJSONPatch,
+			body:         `[{"op": "test", "path": "/year", "value": 1994}, {"op": "replace", "path": "/year", "value": 1996}]`,
+			wantConflict: true,
+		},
+		{
+			name:         "JSON Patch with a missing path",
+			mediaType:    mediaTypeJSONPatch,
+			body:         `[{"op": "remove", "path": "/genres/5"}]`,
+			wantConflict: true,
+		},
+		{
+			name:      "JSON Patch with an unsupported operation",
+			mediaType: mediaTypeJSONPatch,
+			body:      `[{"op": "merge", "path": "/title", "value": "Heat"}]`,
+			wantErr:   `body contains an unsupported operation "merge" (at index 0)`,
+		},
+		{
+			name:      "JSON Patch with the wrong type",
+			mediaType: mediaTypeJSONPatch,
+			body:      `[{"op": "replace", "path": "/year", "value": "1996"}]`,
+			wantErr:   `patch results in incorrect JSON type for field "year"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication()
+
+			movie := &data.Movie{ID: 7, Version: 3, Title: "Heat", Year: 1995, Runtime: 170, Genres: []string{"crime", "drama"}, Upcoming: true}
+
+			r := httptest.NewRequest(http.MethodPatch, "/v1/movies/7", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.mediaType)
+			w := httptest.NewRecorder()
+
+			err := app.applyMoviePatch(w, r, tt.mediaType, movie)
+
+			var conflictErr patchConflictError
+			switch {
+			case tt.wantConflict:
+				if !errors.As(err, &conflictErr) {
+					t.Fatalf("got error %v; want a patchConflictError", err)
+				}
+				return
+			case tt.wantErr != "":
+				if err == nil || errors.As(err, &conflictErr) || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v; want %q", err, tt.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// The ID, version and other fields which patches can't reach must be kept.
+			tt.want.ID, tt.want.Version, tt.want.Upcoming = 7, 3, true
+
+			if !reflect.DeepEqual(*movie, tt.want) {
+				t.Errorf("got %+v; want %+v", *movie, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMediaType(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		want        string
+		wantErr     bool
+	}{
+		{name: "Missing", contentType: "", want: mediaTypeJSON},
+		{name: "Plain", contentType: mediaTypeJSONPatch, want: mediaTypeJSONPatch},
+		{name: "With parameters", contentType: "application/merge-patch+json; charset=utf-8", want: mediaTypeMergePatch},
+		{name: "Invalid", contentType: "application/", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/v1/movies/7", nil)
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+
+			got, err := readMediaType(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v; want error: %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
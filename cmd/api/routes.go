@@ -28,8 +28,69 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.showMovieHandler)
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.UpdateMovieHandler)
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.deleteMovieHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/similar", app.listSimilarMoviesHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/merge", app.mergeMovieHandler)
 
-	// Return the httprouter instance.
-	// Wrap the router with the panic recovery and rateLimit middleware
-	return app.recoverPanic(app.rateLimit(router))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/releases", app.listMovieReleasesHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/releases", app.createMovieReleaseHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/releases/:release_id", app.deleteMovieReleaseHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/tags", app.listMovieTagsHandler)
+	// Attaching and removing tags is limited to users with the "tags:write" permission.
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/tags", app.requirePermission("tags:write", app.addMovieTagsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/tags/:tag", app.requirePermission("tags:write", app.removeMovieTagHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/tags", app.listPopularTagsHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/stats/movies", app.showMovieStatsHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/collections", app.listCollectionsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/collections", app.createCollectionHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/collections/:id", app.showCollectionHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/collections/:id", app.updateCollectionHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id", app.deleteCollectionHandler)
+
+	router.HandlerFunc(http.MethodPost, "/v1/graphql", app.graphQLHandler())
+
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/translations", app.listMovieTranslationsHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/translations/:locale", app.putMovieTranslationHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/translations/:locale", app.deleteMovieTranslationHandler)
+
+	// httprouter doesn't allow a static path segment to share a position with a named
+	// parameter, so routes like /v1/movies/by-external/... can't be registered on the
+	// same router as /v1/movies/:id. Register them on a second router instead, and use a
+	// http.ServeMux to dispatch requests to it based on the path prefix.
+	fixedRouter := httprouter.New()
+	fixedRouter.NotFound = http.HandlerFunc(app.notFoundResponse)
+	fixedRouter.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+
+	fixedRouter.HandlerFunc(http.MethodGet, "/v1/movies/duplicates", app.listDuplicateMoviesHandler)
+	fixedRouter.HandlerFunc(http.MethodGet, "/v1/movies/by-external/:source/:id", app.showMovieByExternalIDHandler)
+	fixedRouter.HandlerFunc(http.MethodPut, "/v1/movies/by-external/:source/:id", app.putMovieByExternalIDHandler)
+
+	mux := http.NewServeMux()
+	mux.Handle("/v1/movies/duplicates", fixedRouter)
+	mux.Handle("/v1/movies/by-external/", fixedRouter)
+	mux.Handle("/", router)
+
+	// The autocomplete endpoint is called as the user types, so rather than going through
+	// the global rateLimit middleware, it has a router of its own which is rate limited
+	// separately with lighter limits.
+	autocompleteRouter := httprouter.New()
+	autocompleteRouter.NotFound = http.HandlerFunc(app.notFoundResponse)
+	autocompleteRouter.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+
+	autocompleteRouter.HandlerFunc(http.MethodGet, "/v1/autocomplete", app.autocompleteHandler)
+
+	root := http.NewServeMux()
+	root.Handle("/v1/autocomplete", app.rateLimitWith(app.config.limiter.autocompleteRPS, app.config.limiter.autocompleteBurst, autocompleteRouter))
+	// Requests are authenticated after being rate limited, so that clients can't use
+	// up database connections by sending lots of bogus tokens, and before the idempotency
+	// middleware, which records the user along with each key.
+	root.Handle("/", app.rateLimit(app.authenticate(app.idempotency(mux))))
+
+	// Wrap the routers with the panic recovery middleware, and that with the requestID
+	// middleware so that errors from panics have a request ID too.
+	return app.requestID(app.recoverPanic(root))
 }
@@ -58,6 +58,9 @@ func (app *application) serve() error {
 		shutdownError <- srv.Shutdown(ctx)
 	}()
 
+	// Start recomputing the duplicate movie clusters in the background.
+	go app.refreshDuplicateClusters()
+
 	// Log a "starting server" message
 	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env)
 
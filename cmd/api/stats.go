@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"greenlight.example.com/internal/data"
+	"greenlight.example.com/internal/validator"
+)
+
+// Add a showMovieStatsHandler for the "GET /v1/stats/movies" endpoint, which returns
+// aggregate statistics about the movies matching the same filters as listMoviesHandler.
+// The statistics are cached for the configured TTL, keyed by the filters.
+func (app *application) showMovieStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.MovieFilters
+		Newest int
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.MovieFilters = app.readMovieFilters(qs, v)
+	input.Newest = app.readInt(qs, "newest", 5, v)
+
+	data.ValidateMovieFilters(v, input.MovieFilters)
+
+	if data.ValidateStats(v, input.Newest); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Use the JSON encoding of the input as the cache key, so that requests with the same
+	// filters share an entry however their query strings are written.
+	key, err := json.Marshal(input)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	stats, ok := app.statsCache.Get(string(key))
+	if !ok {
+		stats, err = app.models.Movies.GetStats(input.MovieFilters, input.Newest)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.statsCache.Set(string(key), stats)
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", fmt.Sprintf("max-age=%d", int(app.config.stats.cacheTTL.Seconds())))
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"stats": stats}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/text/language"
+	"greenlight.example.com/internal/data"
+	"greenlight.example.com/internal/validator"
+)
+
+// Retrieve the "locale" url param from the current request context and parse it as a
+// BCP 47 language tag, returning it in its canonical form (so "EN-gb" becomes "en-GB").
+func (app *application) readLocaleParam(r *http.Request) (string, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	tag, err := language.Parse(params.ByName("locale"))
+	if err != nil {
+		return "", errors.New("invalid locale parameter")
+	}
+	return tag.String(), nil
+}
+
+// The readAcceptLanguage() helper parses the Accept-Language header into a list of
+// language tags ordered by preference. A missing or malformed header yields an empty
+// list, in which case movies are served with their original title.
+func (app *application) readAcceptLanguage(r *http.Request) []language.Tag {
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil {
+		return nil
+	}
+	return tags
+}
+
+// The bestTranslation() helper picks the translation which best matches the client's
+// language preferences, returning nil if none of them is an acceptable match.
+func (app *application) bestTranslation(prefs []language.Tag, translations []*data.Translation) *data.Translation {
+	if len(prefs) == 0 || len(translations) == 0 {
+		return nil
+	}
+
+	supported := make([]language.Tag, len(translations))
+	for i, translation := range translations {
+		supported[i] = language.Make(translation.Locale)
+	}
+
+	_, index, confidence := language.NewMatcher(supported).Match(prefs...)
+	if confidence == language.No {
+		return nil
+	}
+
+	return translations[index]
+}
+
+// The localizeMovies() helper applies the best matching translation to each of the
+// movies and sets the Content-Language and Vary headers accordingly. The response
+// varies on Accept-Language even when no translation was applied, so that caches
+// don't serve one client's localized response to another.
+func (app *application) localizeMovies(r *http.Request, headers http.Header, movies ...*data.Movie) error {
+	headers.Add("Vary", "Accept-Language")
+
+	prefs := app.readAcceptLanguage(r)
+	if len(prefs) == 0 || len(movies) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	translations, err := app.models.Translations.GetAllForMovies(ids)
+	if err != nil {
+		return err
+	}
+
+	// Collect the distinct locales that were applied, in order of first appearance, for
+	// use in the Content-Language header.
+	var locales []string
+
+	for _, movie := range movies {
+		translation := app.bestTranslation(prefs, translations[movie.ID])
+		if translation == nil {
+			continue
+		}
+
+		movie.Localize(translation)
+
+		if !validator.PermittedValue(translation.Locale, locales...) {
+			locales = append(locales, translation.Locale)
+		}
+	}
+
+	if len(locales) > 0 {
+		headers.Set("Content-Language", strings.Join(locales, ", "))
+	}
+
+	return nil
+}
+
+// Add a listMovieTranslationsHandler for the "GET /v1/movies/:id/translations" endpoint.
+func (app *application) listMovieTranslationsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Check the movie exists first, so that we can distinguish between a missing movie
+	// and a movie which simply has no translations.
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	translations, err := app.models.Translations.GetAllForMovie(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Make sure we send an empty JSON array rather than null when there are none.
+	if translations == nil {
+		translations = []*data.Translation{}
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"translations": translations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a putMovieTranslationHandler for the "PUT /v1/movies/:id/translations/:locale"
+// endpoint. This creates the translation if it doesn't exist yet, or replaces it.
+func (app *application) putMovieTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	locale, err := app.readLocaleParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Title    string `json:"title"`
+		Overview string `json:"overview"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	translation := &data.Translation{
+		MovieID:  id,
+		Locale:   locale,
+		Title:    input.Title,
+		Overview: input.Overview,
+	}
+
+	v := validator.New()
+
+	if data.ValidateTranslation(v, translation); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Translations.Upsert(translation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"translation": translation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a deleteMovieTranslationHandler for the "DELETE /v1/movies/:id/translations/:locale"
+// endpoint.
+func (app *application) deleteMovieTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	locale, err := app.readLocaleParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Translations.Delete(id, locale)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "translation successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
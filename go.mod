@@ -9,3 +9,13 @@ require github.com/lib/pq v1.10.9
 require golang.org/x/time v0.3.0
 
 require golang.org/x/crypto v0.14.0
+
+require golang.org/x/text v0.14.0
+
+require github.com/graphql-go/graphql v0.8.1
+
+require github.com/evanphx/json-patch/v5 v5.9.11
+
+require github.com/vmihailenco/msgpack/v5 v5.4.1
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
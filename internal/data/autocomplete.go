@@ -0,0 +1,67 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"greenlight.example.com/internal/validator"
+)
+
+// A Suggestion is a single autocomplete match. Type says what kind of record ID refers
+// to, which is currently always "movie" - people will be suggested too once they are
+// stored.
+type Suggestion struct {
+	ID    int64  `json:"id"`
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	Year  int32  `json:"year,omitempty"`
+}
+
+func ValidateAutocomplete(v *validator.Validator, q string, limit int) {
+	v.Check(q != "", "q", "must be provided")
+	v.Check(len(q) <= 100, "q", "must not be more than 100 bytes long")
+
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 20, "limit", "must be a maximum of 20")
+}
+
+// Autocomplete returns up to limit movies whose titles match the partial query q. It uses
+// the <% word similarity operator, which is backed by movies_title_trgm_idx and so both
+// fast and tolerant of typos. Titles which start with the query are suggested first,
+// followed by the rest in order of how closely they match.
+func (m *MovieModel) Autocomplete(q string, limit int) ([]*Suggestion, error) {
+	query := `
+	SELECT id, title, year
+	FROM movies
+	WHERE $1 <% title
+	ORDER BY starts_with(lower(title), lower($1)) DESC, word_similarity($1, title) DESC, title, id
+	LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, q, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*Suggestion{}
+
+	for rows.Next() {
+		suggestion := Suggestion{Type: "movie"}
+
+		err := rows.Scan(&suggestion.ID, &suggestion.Title, &suggestion.Year)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &suggestion)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
@@ -0,0 +1,394 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.example.com/internal/validator"
+)
+
+// Define a custom ErrUnknownMovie error, returned when a collection refers to a movie
+// which doesn't exist.
+var (
+	ErrUnknownMovie = errors.New("unknown movie")
+)
+
+// A Collection is a named, ordered group of movies, such as a trilogy or a franchise.
+// MovieIDs holds the IDs of its movies in order.
+type Collection struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"-"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	MovieIDs    []int64   `json:"movie_ids"`
+	Version     int32     `json:"version"`
+}
+
+// A MovieCollection describes a collection which a movie belongs to, from the point of
+// view of the movie. Position is the movie's 1-based position within the collection.
+type MovieCollection struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+}
+
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+	v.Check(collection.Name != "", "name", "must be provided")
+	v.Check(len(collection.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	v.Check(len(collection.Description) <= 10_000, "description", "must not be more than 10000 bytes long")
+
+	v.Check(collection.MovieIDs != nil, "movie_ids", "must be provided")
+	v.Check(len(collection.MovieIDs) <= 500, "movie_ids", "must not contain more than 500 movies")
+	v.Check(validator.Unique(collection.MovieIDs), "movie_ids", "must not contain duplicate values")
+
+	for _, id := range collection.MovieIDs {
+		v.Check(id > 0, "movie_ids", "must only contain positive integers")
+	}
+}
+
+// Define a CollectionModel struct type which wraps a sql.DB connection pool.
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+// touchMovies bumps the updated_at time of every movie in a collection. The collections
+// which a movie belongs to are part of its representation, so this is done whenever a
+// collection's name or movies change, to keep the Last-Modified header right.
+func (m CollectionModel) touchMovies(ctx context.Context, tx *sql.Tx, collectionID int64) error {
+	query := `
+	UPDATE movies SET updated_at = NOW()
+	WHERE id IN (SELECT movie_id FROM collections_movies WHERE collection_id = $1)`
+
+	_, err := tx.ExecContext(ctx, query, collectionID)
+	return err
+}
+
+// setMovies replaces the movies in a collection with the given list, numbering their
+// positions from 1 in the order given. If any of the movies doesn't exist, the foreign
+// key constraint is violated and ErrUnknownMovie is returned. Both the old and the new
+// movies are touched.
+func (m CollectionModel) setMovies(ctx context.Context, tx *sql.Tx, collection *Collection) error {
+	err := m.touchMovies(ctx, tx, collection.ID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	DELETE FROM collections_movies
+	WHERE collection_id = $1`
+
+	_, err = tx.ExecContext(ctx, query, collection.ID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+	INSERT INTO collections_movies (collection_id, movie_id, position)
+	SELECT $1, m.movie_id, m.position
+	FROM unnest($2::bigint[]) WITH ORDINALITY AS m(movie_id, position)`
+
+	_, err = tx.ExecContext(ctx, query, collection.ID, pq.Array(collection.MovieIDs))
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "collections_movies" violates foreign key constraint "collections_movies_movie_id_fkey"`:
+			return ErrUnknownMovie
+		default:
+			return err
+		}
+	}
+
+	return m.touchMovies(ctx, tx, collection.ID)
+}
+
+// Insert a new collection along with its movies.
+func (m CollectionModel) Insert(collection *Collection) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO collections (name, description)
+	VALUES ($1, $2)
+	RETURNING id, created_at, version`
+
+	err = tx.QueryRowContext(ctx, query, collection.Name, collection.Description).Scan(
+		&collection.ID,
+		&collection.CreatedAt,
+		&collection.Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = m.setMovies(ctx, tx, collection)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get a specific collection, along with the IDs of its movies in order.
+func (m CollectionModel) Get(id int64) (*Collection, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT id, created_at, name, description, version,
+		ARRAY(SELECT movie_id FROM collections_movies WHERE collection_id = collections.id ORDER BY position)
+	FROM collections
+	WHERE id = $1`
+
+	var collection Collection
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&collection.ID,
+		&collection.CreatedAt,
+		&collection.Name,
+		&collection.Description,
+		&collection.Version,
+		pq.Array(&collection.MovieIDs),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &collection, nil
+}
+
+// Update a collection and replace its movies, using the version number to guard against
+// concurrent edits in the same way as MovieModel.Update().
+func (m CollectionModel) Update(collection *Collection) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	query := `
+	UPDATE collections
+	SET name = $1, description = $2, version = version + 1
+	WHERE id = $3 AND version = $4
+	RETURNING version`
+
+	args := []any{collection.Name, collection.Description, collection.ID, collection.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&collection.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = m.setMovies(ctx, tx, collection)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete a specific collection. Its movies are left in place, apart from having their
+// updated_at time bumped.
+func (m CollectionModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	err = m.touchMovies(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	DELETE FROM collections
+	WHERE id = $1`
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// GetAll returns a page of collections, optionally filtered by a full-text search on
+// their name.
+func (m CollectionModel) GetAll(name string, filters Filters) ([]*Collection, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, name, description, version,
+		ARRAY(SELECT movie_id FROM collections_movies WHERE collection_id = collections.id ORDER BY position)
+	FROM collections
+	WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+	ORDER BY %s
+	LIMIT $2 OFFSET $3`, orderBy(filters.sortKeys(), nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	collections := []*Collection{}
+
+	for rows.Next() {
+		var collection Collection
+
+		err := rows.Scan(
+			&totalRecords,
+			&collection.ID,
+			&collection.CreatedAt,
+			&collection.Name,
+			&collection.Description,
+			&collection.Version,
+			pq.Array(&collection.MovieIDs),
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		collections = append(collections, &collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return collections, metadata, nil
+}
+
+// GetAllForMovie returns the collections which a specific movie belongs to, along with
+// its position in each of them.
+func (m CollectionModel) GetAllForMovie(movieID int64) ([]*MovieCollection, error) {
+	query := `
+	SELECT c.id, c.name, cm.position
+	FROM collections c
+	INNER JOIN collections_movies cm ON cm.collection_id = c.id
+	WHERE cm.movie_id = $1
+	ORDER BY c.name, c.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := []*MovieCollection{}
+
+	for rows.Next() {
+		var collection MovieCollection
+
+		err := rows.Scan(&collection.ID, &collection.Name, &collection.Position)
+		if err != nil {
+			return nil, err
+		}
+
+		collections = append(collections, &collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+// GetAllForMovies returns the collections which each of the given movies belong to, in
+// a single query, keyed by movie ID. Movies which don't belong to any collections are
+// left out of the map.
+func (m CollectionModel) GetAllForMovies(movieIDs []int64) (map[int64][]*MovieCollection, error) {
+	collections := make(map[int64][]*MovieCollection)
+
+	if len(movieIDs) == 0 {
+		return collections, nil
+	}
+
+	query := `
+	SELECT cm.movie_id, c.id, c.name, cm.position
+	FROM collections c
+	INNER JOIN collections_movies cm ON cm.collection_id = c.id
+	WHERE cm.movie_id = ANY($1)
+	ORDER BY cm.movie_id, c.name, c.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movieID int64
+		var collection MovieCollection
+
+		err := rows.Scan(&movieID, &collection.ID, &collection.Name, &collection.Position)
+		if err != nil {
+			return nil, err
+		}
+
+		collections[movieID] = append(collections[movieID], &collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
@@ -0,0 +1,64 @@
+package data
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Define an error that our UnmarshalJSON() method can return if we're unable to parse the
+// JSON string as a date.
+var ErrInvalidDateFormat = errors.New("invalid date format")
+
+// DateLayout is the layout used for calendar dates in JSON and query strings.
+const DateLayout = "2006-01-02"
+
+// Declare a custom Date type for calendar dates without a time of day, which wraps a
+// time.Time and is represented in JSON as a "YYYY-MM-DD" string.
+type Date struct {
+	time.Time
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.Format(DateLayout))), nil
+}
+
+// Implement a UnmarshalJSON() method on the Date type so that it satisfies the
+// json.Unmarshaler interface. As with Runtime, this needs a pointer receiver so that it
+// can modify the receiver.
+func (d *Date) UnmarshalJSON(jsonValue []byte) error {
+	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
+	if err != nil {
+		return ErrInvalidDateFormat
+	}
+
+	t, err := time.Parse(DateLayout, unquotedJSONValue)
+	if err != nil {
+		return ErrInvalidDateFormat
+	}
+
+	d.Time = t
+
+	return nil
+}
+
+// Scan implements the sql.Scanner interface, so that a Date can be read directly from a
+// PostgreSQL date column.
+func (d *Date) Scan(src any) error {
+	t, ok := src.(time.Time)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into Date", src)
+	}
+
+	d.Time = t
+
+	return nil
+}
+
+// Value implements the driver.Valuer interface, so that a Date can be used directly as
+// a placeholder parameter.
+func (d Date) Value() (driver.Value, error) {
+	return d.Format(DateLayout), nil
+}
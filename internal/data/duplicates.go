@@ -0,0 +1,289 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Two movies are suspected duplicates when the trigram similarity of their titles is at
+// least duplicateTitleSimilarity, and their release years are at most duplicateYearSpread
+// apart (to allow for films which were released in different years in different markets).
+const (
+	duplicateTitleSimilarity = 0.6
+	duplicateYearSpread      = 1
+)
+
+// A DuplicateCluster is a group of movies which are all suspected of being duplicates
+// of one another, ordered by ID.
+type DuplicateCluster struct {
+	Movies []*Movie `json:"movies"`
+}
+
+// FindDuplicates returns up to 5 existing movies which look like duplicates of the given
+// one, most similar first. The Score field holds the trigram similarity of the titles.
+// The title % $1 condition lets Postgres use the trigram index on the title column to
+// find candidates, before the stricter similarity check is applied.
+func (m *MovieModel) FindDuplicates(movie *Movie) ([]*SimilarMovie, error) {
+	query := `
+	SELECT id, created_at, title, year, runtime, genres, version,
+		round(similarity(title, $1)::numeric, 4)::float8 AS score
+	FROM movies
+	WHERE title % $1
+	AND similarity(title, $1) >= $2
+	AND year BETWEEN $3::integer - $4::integer AND $3::integer + $4::integer
+	AND id <> $5
+	ORDER BY score DESC, id ASC
+	LIMIT 5`
+
+	args := []any{movie.Title, duplicateTitleSimilarity, movie.Year, duplicateYearSpread, movie.ID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	duplicates := []*SimilarMovie{}
+
+	for rows.Next() {
+		duplicate := SimilarMovie{Movie: &Movie{}}
+
+		err := rows.Scan(
+			&duplicate.ID,
+			&duplicate.CreatedAt,
+			&duplicate.Title,
+			&duplicate.Year,
+			&duplicate.Runtime,
+			pq.Array(&duplicate.Genres),
+			&duplicate.Version,
+			&duplicate.Score,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		duplicates = append(duplicates, &duplicate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return duplicates, nil
+}
+
+// RefreshDuplicateClusters recomputes the suspected duplicate clusters across the whole
+// movies table and stores them in the movie_duplicates table, which GetDuplicateClusters
+// reads from. It first finds every pair of suspected duplicates, then joins pairs which
+// share a movie into clusters (so if A looks like B, and B looks like C, then A, B and C
+// form a single cluster), each identified by its lowest movie ID.
+//
+// Finding the pairs means comparing every title with every other one, which is too slow
+// to do on each request once the catalog is large, so this is run periodically in the
+// background instead. The trigram index on the title column keeps each comparison cheap.
+func (m *MovieModel) RefreshDuplicateClusters() error {
+	query := `
+	SELECT a.id, b.id
+	FROM movies a
+	INNER JOIN movies b ON a.id < b.id
+		AND a.title % b.title
+		AND similarity(a.title, b.title) >= $1
+		AND abs(a.year - b.year) <= $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, duplicateTitleSimilarity, duplicateYearSpread)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// Use a union-find structure to join the pairs into clusters. Each movie ID maps to
+	// its parent, and the root of each tree identifies the cluster. The root is always the
+	// lowest ID in the tree.
+	parent := make(map[int64]int64)
+
+	var find func(id int64) int64
+	find = func(id int64) int64 {
+		if _, ok := parent[id]; !ok {
+			parent[id] = id
+		}
+		if parent[id] != id {
+			parent[id] = find(parent[id])
+		}
+		return parent[id]
+	}
+
+	for rows.Next() {
+		var a, b int64
+
+		err := rows.Scan(&a, &b)
+		if err != nil {
+			return err
+		}
+
+		rootA, rootB := find(a), find(b)
+		if rootA != rootB {
+			parent[max(rootA, rootB)] = min(rootA, rootB)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	movieIDs := make([]int64, 0, len(parent))
+	clusterIDs := make([]int64, 0, len(parent))
+	for id := range parent {
+		movieIDs = append(movieIDs, id)
+		clusterIDs = append(clusterIDs, find(id))
+	}
+
+	// Replace the stored clusters in a transaction, so that readers see either the old
+	// clusters or the new ones. Movies which were deleted since the pairs were found are
+	// skipped by the join.
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_duplicates`)
+	if err != nil {
+		return err
+	}
+
+	query = `
+	INSERT INTO movie_duplicates (movie_id, cluster_id)
+	SELECT d.movie_id, d.cluster_id
+	FROM unnest($1::bigint[], $2::bigint[]) AS d(movie_id, cluster_id)
+	INNER JOIN movies ON movies.id = d.movie_id`
+
+	_, err = tx.ExecContext(ctx, query, pq.Array(movieIDs), pq.Array(clusterIDs))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetDuplicateClusters returns a page of the suspected duplicate clusters stored by
+// RefreshDuplicateClusters, ordered by their lowest movie ID, so they may be a little out
+// of date. Clusters which have been left with a single movie, because the others were
+// deleted, are skipped.
+func (m *MovieModel) GetDuplicateClusters(filters Filters) ([]*DuplicateCluster, Metadata, error) {
+	query := `
+	SELECT count(*) OVER(), cluster_id, array_agg(movie_id ORDER BY movie_id)
+	FROM movie_duplicates
+	GROUP BY cluster_id
+	HAVING count(*) > 1
+	ORDER BY cluster_id
+	LIMIT $1 OFFSET $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	var clusterIDs [][]int64
+
+	for rows.Next() {
+		var clusterID int64
+		var ids []int64
+
+		err := rows.Scan(&totalRecords, &clusterID, pq.Array(&ids))
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		clusterIDs = append(clusterIDs, ids)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	// Fetch the movies in this page of clusters with a single query.
+	var ids []int64
+	for _, cluster := range clusterIDs {
+		ids = append(ids, cluster...)
+	}
+
+	movies, err := m.getByIDs(ctx, ids)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	clusters := make([]*DuplicateCluster, 0, len(clusterIDs))
+	for _, cluster := range clusterIDs {
+		duplicateCluster := &DuplicateCluster{}
+		for _, id := range cluster {
+			if movie, ok := movies[id]; ok {
+				duplicateCluster.Movies = append(duplicateCluster.Movies, movie)
+			}
+		}
+		clusters = append(clusters, duplicateCluster)
+	}
+
+	return clusters, metadata, nil
+}
+
+// getByIDs fetches the movies with the given IDs, keyed by ID. IDs which don't exist are
+// silently skipped.
+func (m *MovieModel) getByIDs(ctx context.Context, ids []int64) (map[int64]*Movie, error) {
+	movies := make(map[int64]*Movie)
+
+	if len(ids) == 0 {
+		return movies, nil
+	}
+
+	query := `
+	SELECT id, created_at, title, year, runtime, genres, version
+	FROM movies
+	WHERE id = ANY($1)`
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movies[movie.ID] = &movie
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
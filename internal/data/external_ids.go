@@ -0,0 +1,187 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.example.com/internal/validator"
+)
+
+// Declare the regular expressions used to sanity check the identifiers from each of the
+// external sources that we accept. The keys of this map double as the safelist of
+// supported sources.
+var ExternalIDRX = map[string]*regexp.Regexp{
+	"imdb": regexp.MustCompile(`^tt\d{7,10}$`),
+	"tmdb": regexp.MustCompile(`^\d{1,10}$`),
+}
+
+// An ExternalID maps an identifier from a partner source (such as IMDb or TMDB) to one
+// of our own movies.
+type ExternalID struct {
+	MovieID int64  `json:"movie_id"`
+	Source  string `json:"source"`
+	ID      string `json:"id"`
+}
+
+func ValidateExternalID(v *validator.Validator, externalID *ExternalID) {
+	rx, ok := ExternalIDRX[externalID.Source]
+
+	v.Check(externalID.Source != "", "source", "must be provided")
+	v.Check(ok, "source", "must be a supported external source")
+
+	v.Check(externalID.ID != "", "id", "must be provided")
+	if ok {
+		v.Check(validator.Matches(externalID.ID, rx), "id", "must be a valid identifier for the source")
+	}
+}
+
+// Define an ExternalIDModel struct type which wraps a sql.DB connection pool.
+type ExternalIDModel struct {
+	DB *sql.DB
+}
+
+// GetMovie retrieves the movie mapped to the given external source and identifier.
+func (m ExternalIDModel) GetMovie(source, id string) (*Movie, error) {
+	query := `
+	SELECT m.id, m.created_at, m.title, m.year, m.runtime, m.genres, m.version,
+		EXISTS (SELECT 1 FROM releases r WHERE r.movie_id = m.id AND r.release_date > CURRENT_DATE)
+	FROM external_ids e
+	INNER JOIN movies m ON m.id = e.movie_id
+	WHERE e.source = $1 AND e.external_id = $2`
+
+	var movie Movie
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, source, id).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+		&movie.Upcoming,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
+
+// UpsertMovie creates or updates the movie mapped to the given external identifier, so
+// that replaying the same partner feed entry any number of times leaves the catalog in
+// the same state. If the identifier isn't mapped yet, a new movie is inserted along with
+// the mapping and created is true. Otherwise the mapped movie is updated in place - but
+// only if something actually changed, so that replays don't bump the version number.
+// On return the movie struct holds the stored record, including its id and version.
+//
+// If two requests race to create the same mapping, the loser gets an ErrEditConflict
+// error and can simply retry (at which point it will find the mapping and update).
+func (m ExternalIDModel) UpsertMovie(externalID *ExternalID, movie *Movie) (created bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	// Look up the currently mapped movie, locking its row so that concurrent replays of
+	// the same entry are serialized.
+	query := `
+	SELECT m.id, m.created_at, m.title, m.year, m.runtime, m.genres, m.version
+	FROM external_ids e
+	INNER JOIN movies m ON m.id = e.movie_id
+	WHERE e.source = $1 AND e.external_id = $2
+	FOR UPDATE OF m`
+
+	var existing Movie
+
+	err = tx.QueryRowContext(ctx, query, externalID.Source, externalID.ID).Scan(
+		&existing.ID,
+		&existing.CreatedAt,
+		&existing.Title,
+		&existing.Year,
+		&existing.Runtime,
+		pq.Array(&existing.Genres),
+		&existing.Version,
+	)
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		query = `
+		INSERT INTO movies (title, year, runtime, genres)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+		args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+		if err != nil {
+			return false, err
+		}
+
+		query = `
+		INSERT INTO external_ids (source, external_id, movie_id)
+		VALUES ($1, $2, $3)`
+
+		_, err = tx.ExecContext(ctx, query, externalID.Source, externalID.ID, movie.ID)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "external_ids_pkey"`:
+				return false, ErrEditConflict
+			default:
+				return false, err
+			}
+		}
+
+		created = true
+
+	case err != nil:
+		return false, err
+
+	default:
+		movie.ID = existing.ID
+		movie.CreatedAt = existing.CreatedAt
+		movie.Version = existing.Version
+
+		unchanged := movie.Title == existing.Title &&
+			movie.Year == existing.Year &&
+			movie.Runtime == existing.Runtime &&
+			slices.Equal(movie.Genres, existing.Genres)
+
+		if !unchanged {
+			query = `
+			UPDATE movies
+			SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1, updated_at = NOW()
+			WHERE id = $5
+			RETURNING version`
+
+			args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.ID}
+
+			err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	externalID.MovieID = movie.ID
+
+	return created, tx.Commit()
+}
@@ -0,0 +1,120 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"greenlight.example.com/internal/validator"
+)
+
+// FacetSafelist holds the facets which can be requested alongside the movie list.
+var FacetSafelist = []string{"genres", "year", "decade", "runtime_bucket"}
+
+// facetQueries maps each facet in FacetSafelist to a query over the "matched" common
+// table expression, returning the facet name, a value, the number of matching movies
+// with that value, and a key for ordering the values within the facet. Genres are
+// ordered by descending count, and everything else in its natural order. Runtimes are
+// grouped into bands so that there are only a handful of values.
+var facetQueries = map[string]string{
+	"genres": `
+	SELECT 'genres', genre, count(*), -count(*)
+	FROM matched, unnest(genres) AS genre
+	GROUP BY genre`,
+
+	"year": `
+	SELECT 'year', year::text, count(*), year
+	FROM matched
+	GROUP BY year`,
+
+	"decade": `
+	SELECT 'decade', (year / 10 * 10)::text || 's', count(*), year / 10 * 10
+	FROM matched
+	GROUP BY year / 10`,
+
+	"runtime_bucket": `
+	SELECT 'runtime_bucket', CASE
+			WHEN runtime < 90 THEN 'under_90'
+			WHEN runtime < 120 THEN '90_to_119'
+			WHEN runtime < 150 THEN '120_to_149'
+			ELSE '150_and_over'
+		END, count(*), min(runtime)
+	FROM matched
+	GROUP BY runtime < 90, runtime < 120, runtime < 150`,
+}
+
+// A FacetCount holds the number of movies which have a particular value for a facet.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+func ValidateFacets(v *validator.Validator, facets []string) {
+	v.Check(validator.Unique(facets), "facets", "must not contain duplicate values")
+
+	for _, facet := range facets {
+		v.Check(validator.PermittedValue(facet, FacetSafelist...), "facets", "invalid facet value")
+	}
+}
+
+// GetFacets counts the movies matching the given filters for each value of each of the
+// requested facets, using the same filter condition as GetAll(). Pagination doesn't
+// apply, so the counts cover every matching movie rather than just the current page.
+func (m *MovieModel) GetFacets(movieFilters MovieFilters, facets []string) (map[string][]*FacetCount, error) {
+	results := make(map[string][]*FacetCount)
+
+	if len(facets) == 0 {
+		return results, nil
+	}
+
+	// Build a UNION of the queries for the requested facets. Only the fixed queries from
+	// facetQueries are interpolated, never the client's input.
+	parts := make([]string, 0, len(facets))
+	for _, facet := range facets {
+		facetQuery, ok := facetQueries[facet]
+		if !ok {
+			panic("unsafe facet parameter:" + facet)
+		}
+		parts = append(parts, facetQuery)
+		results[facet] = []*FacetCount{}
+	}
+
+	condition, args := m.filtersCondition(movieFilters)
+
+	query := fmt.Sprintf(`
+	WITH matched AS (
+		SELECT year, runtime, genres
+		FROM movies
+		WHERE %s
+	)
+	SELECT facet, value, count FROM (%s) AS facets (facet, value, count, sort_key)
+	ORDER BY facet, sort_key, value`, condition, strings.Join(parts, "\n\tUNION ALL"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var facet string
+		var count FacetCount
+
+		err := rows.Scan(&facet, &count.Value, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		results[facet] = append(results[facet], &count)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
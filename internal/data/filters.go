@@ -1,40 +1,141 @@
 package data
 
 import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
+	"slices"
 	"strings"
+	"time"
 
 	"greenlight.example.com/internal/validator"
 )
 
 type Filters struct {
-	Page         int
-	PageSize     int
-	Sort         string
-	SortSafelist []string // Add a SortSafelist field to hold supported sort fields
-}
-
-// Check that the client-provided Sort field matches one of the entries in our safelist
-// and if it does, extract the column name from Sort field by stripping the leading
-// hyphen character (if one exists).
-func (f Filters) sortColumn() string {
-	for _, safeValue := range f.SortSafelist {
-		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+	Page           int
+	PageSize       int
+	Sort           string   // Comma-separated sort keys, each prefixed with "-" for descending order
+	SortSafelist   []string // Add a SortSafelist field to hold supported sort fields
+	Cursor         string   // Opaque keyset cursor; when set, Page is ignored
+	CursorsAllowed bool     // Whether the endpoint supports cursors, for the page error message
+}
+
+// MaxOffset is the maximum number of records which page-based pagination can skip. Deep
+// OFFSETs get slower the further they go, as every skipped row still has to be read, so
+// clients should page further than this with a cursor instead.
+const MaxOffset = 10_000
+
+// PageReachable reports whether the given page number can be requested without skipping
+// more than MaxOffset records.
+func (f Filters) PageReachable(page int) bool {
+	return f.PageSize <= 0 || page-1 <= MaxOffset/f.PageSize
+}
+
+// A cursor marks a position in a sorted result set for keyset pagination. It holds the
+// sort the cursor was issued for, the values of each of the sort keys (including the id
+// tie-breaker) for the row at the edge of the page, and whether it points backwards (to
+// the rows before that row) or forwards (to the rows after it). The values are stored as
+// strings so that they survive the round trip through JSON without any loss of precision.
+type cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+	Prev   bool     `json:"p,omitempty"`
+}
+
+// Encode the cursor into an opaque, URL-safe string for the client.
+func (c cursor) encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// Decode a cursor string received from the client.
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, errors.New("invalid cursor")
+	}
+
+	err = json.Unmarshal(js, &c)
+	if err != nil || len(c.Values) == 0 {
+		return cursor{}, errors.New("invalid cursor")
+	}
+
+	return c, nil
+}
+
+// A sortKey is a single column in the sort order, along with its direction ("ASC" or
+// "DESC").
+type sortKey struct {
+	column    string
+	direction string
+}
+
+// Split the client-provided Sort field into its keys, checking that each of them matches
+// one of the entries in our safelist, and extract the column names by stripping the
+// leading hyphen characters (if they exist). Unless the sort already includes id, an id
+// ASC key is added at the end so that the order is always deterministic.
+func (f Filters) sortKeys() []sortKey {
+	var keys []sortKey
+	hasID := false
+
+	for _, value := range strings.Split(f.Sort, ",") {
+		// Filters struct should already be validated before it hit this function but incase there is
+		// a sql injection possibility, we can afford to panic as it should never get here.
+		if !slices.Contains(f.SortSafelist, value) {
+			panic("unsafe sort parameter:" + f.Sort)
 		}
+
+		key := sortKey{column: strings.TrimPrefix(value, "-"), direction: "ASC"}
+		if strings.HasPrefix(value, "-") {
+			key.direction = "DESC"
+		}
+
+		hasID = hasID || key.column == "id"
+		keys = append(keys, key)
 	}
-	// Filters struct should already be validated before it hit this function but incase there is
-	// a sql injection possibility, we can afford to panic as it should never get here.
-	panic("unsafe sort parameter:" + f.Sort)
+
+	if !hasID {
+		keys = append(keys, sortKey{column: "id", direction: "ASC"})
+	}
+
+	return keys
 }
 
-// Return the sort direction("ASC" or "DESC") depending on the prefix character of the
-// sort field
-func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
-		return "DESC"
+// Return the columns of the sort keys, including the id tie-breaker.
+func (f Filters) sortColumns() []string {
+	keys := f.sortKeys()
+
+	columns := make([]string, len(keys))
+	for i, key := range keys {
+		columns[i] = key.column
 	}
-	return "ASC"
+
+	return columns
+}
+
+// Return the ORDER BY clause for the sort keys. Sort columns which are computed rather
+// than stored can be mapped to the SQL expression for them in expressions.
+func orderBy(keys []sortKey, expressions map[string]string) string {
+	terms := make([]string, len(keys))
+	for i, key := range keys {
+		terms[i] = sortExpression(key.column, expressions) + " " + key.direction
+	}
+
+	return strings.Join(terms, ", ")
+}
+
+// Return the SQL expression for a sort column, which is the column itself unless it is
+// mapped to something else in expressions.
+func sortExpression(column string, expressions map[string]string) string {
+	if expression, ok := expressions[column]; ok {
+		return expression
+	}
+	return column
 }
 
 // Return sql command 'limit' parameter representing page_size.
@@ -47,25 +148,202 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// Return the ORDER BY clause for the query and, when paging with a cursor, an extra
+// WHERE condition which restricts the results to the rows after (or before) the cursor,
+// along with the values for its placeholders (numbered from the given placeholder). The
+// condition compares the sort keys in turn, each one only coming into play when all of
+// the keys before it are equal, which mirrors the order. When paging backwards the order
+// is reversed, so the caller must reverse the rows it reads to get them back into the
+// requested order. Sort columns which are computed rather than stored can be mapped to
+// the SQL expression for them in expressions.
+func (f Filters) keyset(placeholder int, expressions map[string]string) (condition, order string, args []any) {
+	keys := f.sortKeys()
+
+	if f.Cursor == "" {
+		return "", orderBy(keys, expressions), nil
+	}
+
+	// As with sortKeys(), the cursor should already have been validated, so panic if it
+	// can't be decoded or doesn't have a value for each of the keys.
+	c, err := decodeCursor(f.Cursor)
+	if err != nil || len(c.Values) != len(keys) {
+		panic("unsafe cursor parameter:" + f.Cursor)
+	}
+
+	if c.Prev {
+		for i := range keys {
+			if keys[i].direction == "ASC" {
+				keys[i].direction = "DESC"
+			} else {
+				keys[i].direction = "ASC"
+			}
+		}
+	}
+
+	terms := make([]string, len(keys))
+	for i, key := range keys {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, fmt.Sprintf("%s = $%d", sortExpression(keys[j].column, expressions), placeholder+j))
+		}
+
+		op := ">"
+		if key.direction == "DESC" {
+			op = "<"
+		}
+		parts = append(parts, fmt.Sprintf("%s %s $%d", sortExpression(key.column, expressions), op, placeholder+i))
+
+		terms[i] = "(" + strings.Join(parts, " AND ") + ")"
+		args = append(args, c.Values[i])
+	}
+
+	condition = "AND (" + strings.Join(terms, " OR ") + ")"
+
+	return condition, orderBy(keys, expressions), args
+}
+
+// Return whether the cursor (if any) points backwards.
+func (f Filters) pagingBackwards() bool {
+	c, err := decodeCursor(f.Cursor)
+	return err == nil && c.Prev
+}
+
+// Return a cursor for the row with the given values for each of the sort keys, pointing
+// either backwards or forwards.
+func (f Filters) cursorFor(values []any, prev bool) string {
+	c := cursor{Sort: f.Sort, Prev: prev}
+	for _, value := range values {
+		c.Values = append(c.Values, fmt.Sprint(value))
+	}
+	return c.encode()
+}
+
 func ValidateFilters(v *validator.Validator, f Filters) {
 	// Check that the page and page_size parameters contain sensible values
 	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 10_000_000, "page", "must be maximum of 10 million")
+	if f.CursorsAllowed {
+		v.Check(f.PageReachable(f.Page), "page", fmt.Sprintf("must not skip more than %d records, use the cursor from the metadata to page further", MaxOffset))
+	} else {
+		v.Check(f.PageReachable(f.Page), "page", fmt.Sprintf("must not skip more than %d records", MaxOffset))
+	}
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.Page <= 100, "page_size", "must be a maximum of 100")
+	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+
+	// Check that each of the keys in the sort parameter matches a value in the safelist,
+	// and that no column is sorted by more than once.
+	values := strings.Split(f.Sort, ",")
+	columns := make([]string, len(values))
+	sortValid := true
+
+	for i, value := range values {
+		sortValid = sortValid && validator.PermittedValue(value, f.SortSafelist...)
+		columns[i] = strings.TrimPrefix(value, "-")
+	}
+
+	v.Check(len(values) <= 3, "sort", "must not contain more than 3 values")
+	v.Check(sortValid, "sort", "invalid sort values")
+	v.Check(validator.Unique(columns), "sort", "must not sort by the same column more than once")
+
+	// If a cursor was provided, check that it can be decoded and that it was issued for
+	// the same sort order. A cursor replaces the page number, so don't allow both.
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		v.Check(err == nil, "cursor", "must be a cursor returned by a previous request")
+		v.Check(err != nil || c.Sort == f.Sort, "cursor", "must be used with the same sort as the request it was returned by")
+		v.Check(err != nil || !sortValid || len(c.Values) == len(f.sortKeys()), "cursor", "must be a cursor returned by a previous request")
+		v.Check(f.Page == 1, "page", "must not be provided along with a cursor")
+	}
+}
+
+// Check that each of the fields requested in a sparse fieldset is in the given safelist.
+func ValidateFields(v *validator.Validator, fields []string, safelist []string) {
+	v.Check(validator.Unique(fields), "fields", "must not contain duplicate values")
 
-	// Check that the sort parameter matched a value in the safelist
-	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort values")
+	for _, field := range fields {
+		v.Check(validator.PermittedValue(field, safelist...), "fields", "invalid field value")
+	}
+}
+
+// MovieFilters holds the optional filters which MovieModel.GetAll() applies to the
+// movies table. Each filter is only applied if its field is set to a non-zero value.
+type MovieFilters struct {
+	Title          string
+	Genres         []string // Movies must have all of these genres
+	GenresAny      []string // Movies must have at least one of these genres
+	ExcludeGenres  []string // Movies must have none of these genres
+	Tags           []string
+	IDs            []int64
+	CollectionID   int64
+	YearMin        int
+	YearMax        int
+	RuntimeMin     int
+	RuntimeMax     int
+	Upcoming       bool         // Only movies whose first release is still in the future
+	ReleasedAfter  Date         // Only movies first released on or after this date
+	ReleasedBefore Date         // Only movies first released on or before this date
+	Query          *SearchQuery // Parsed structured search query, from the q parameter
+}
+
+func ValidateMovieFilters(v *validator.Validator, f MovieFilters) {
+	if f.Query != nil {
+		ValidateSearchQuery(v, f.Query)
+	}
+
+	v.Check(f.CollectionID >= 0, "collection", "must be a positive integer")
+
+	v.Check(len(f.Genres) <= 5, "genres", "must not contain more than 5 genres")
+	v.Check(len(f.GenresAny) <= 20, "genres_any", "must not contain more than 20 genres")
+	v.Check(len(f.ExcludeGenres) <= 20, "exclude_genres", "must not contain more than 20 genres")
+
+	v.Check(len(f.Tags) <= 20, "tags", "must not contain more than 20 tags")
+	for _, tag := range f.Tags {
+		v.Check(tag != "", "tags", "must not contain empty tags")
+	}
+
+	v.Check(len(f.IDs) <= 100, "ids", "must not contain more than 100 ids")
+	for _, id := range f.IDs {
+		v.Check(id > 0, "ids", "must only contain positive integers")
+	}
+
+	// Zero means that the bound isn't set, so only check the bounds which are.
+	maxYear := time.Now().Year() + 10
+	if f.YearMin != 0 {
+		v.Check(f.YearMin >= 1888 && f.YearMin <= maxYear, "year_min", fmt.Sprintf("must be between 1888 and %d", maxYear))
+	}
+	if f.YearMax != 0 {
+		v.Check(f.YearMax >= 1888 && f.YearMax <= maxYear, "year_max", fmt.Sprintf("must be between 1888 and %d", maxYear))
+	}
+	if f.YearMin != 0 && f.YearMax != 0 {
+		v.Check(f.YearMin <= f.YearMax, "year_min", "must not be greater than year_max")
+	}
+
+	v.Check(f.RuntimeMin >= 0, "runtime_min", "must be a positive integer")
+	v.Check(f.RuntimeMax >= 0, "runtime_max", "must be a positive integer")
+	if f.RuntimeMin != 0 && f.RuntimeMax != 0 {
+		v.Check(f.RuntimeMin <= f.RuntimeMax, "runtime_min", "must not be greater than runtime_max")
+	}
+
+	if !f.ReleasedAfter.IsZero() && !f.ReleasedBefore.IsZero() {
+		v.Check(!f.ReleasedAfter.After(f.ReleasedBefore.Time), "released_after", "must not be later than released_before")
+	}
+}
 
+// Return a sql.NullTime for an optional date filter, which is NULL if the date is unset.
+func nullDate(d Date) sql.NullTime {
+	return sql.NullTime{Time: d.Time, Valid: !d.IsZero()}
 }
 
-// Define a new Metadata struct for holding the pagination results.
+// Define a new Metadata struct for holding the pagination results. The cursor fields are
+// only populated by endpoints which support keyset pagination, and when paging with a
+// cursor the page number and total fields are left empty.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
 // The calculateMetadata() function calculates the appropriate pagination metadata
@@ -0,0 +1,202 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+
+	"greenlight.example.com/internal/validator"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	t.Run("Round trip", func(t *testing.T) {
+		want := cursor{Sort: "-year,title", Values: []string{"2008", "The Dark Knight", "42"}, Prev: true}
+
+		got, err := decodeCursor(want.encode())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v; want %+v", got, want)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{name: "Not base64", cursor: "not a cursor!"},
+		{name: "Not JSON", cursor: "bm90IGpzb24"},
+		{name: "No values", cursor: cursor{Sort: "id"}.encode()},
+		{name: "Padded base64", cursor: "eyJzIjoiaWQiLCJ2IjpbIjEiXX0="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeCursor(tt.cursor)
+			if err == nil {
+				t.Errorf("got no error for %q", tt.cursor)
+			}
+		})
+	}
+}
+
+func TestKeyset(t *testing.T) {
+	safelist := []string{"id", "title", "year", "relevance", "-id", "-title", "-year", "-relevance"}
+
+	tests := []struct {
+		name          string
+		filters       Filters
+		expressions   map[string]string
+		wantCondition string
+		wantOrder     string
+		wantArgs      []any
+	}{
+		{
+			name:      "No cursor",
+			filters:   Filters{Sort: "-year"},
+			wantOrder: "year DESC, id ASC",
+		},
+		{
+			name:      "Sort by id has no tie-breaker",
+			filters:   Filters{Sort: "-id"},
+			wantOrder: "id DESC",
+		},
+		{
+			name: "Forwards",
+			filters: Filters{
+				Sort:   "-year,title",
+				Cursor: cursor{Sort: "-year,title", Values: []string{"2008", "Heat", "7"}}.encode(),
+			},
+			wantCondition: "AND ((year < $5) OR (year = $5 AND title > $6) OR (year = $5 AND title = $6 AND id > $7))",
+			wantOrder:     "year DESC, title ASC, id ASC",
+			wantArgs:      []any{"2008", "Heat", "7"},
+		},
+		{
+			name: "Backwards reverses the order",
+			filters: Filters{
+				Sort:   "-year",
+				Cursor: cursor{Sort: "-year", Values: []string{"2008", "7"}, Prev: true}.encode(),
+			},
+			wantCondition: "AND ((year > $5) OR (year = $5 AND id < $6))",
+			wantOrder:     "year ASC, id DESC",
+			wantArgs:      []any{"2008", "7"},
+		},
+		{
+			name: "Computed columns use their expression",
+			filters: Filters{
+				Sort:   "relevance",
+				Cursor: cursor{Sort: "relevance", Values: []string{"-0.5", "7"}}.encode(),
+			},
+			expressions:   map[string]string{"relevance": "-rank"},
+			wantCondition: "AND ((-rank > $5) OR (-rank = $5 AND id > $6))",
+			wantOrder:     "-rank ASC, id ASC",
+			wantArgs:      []any{"-0.5", "7"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filters.SortSafelist = safelist
+
+			condition, order, args := tt.filters.keyset(5, tt.expressions)
+
+			if condition != tt.wantCondition {
+				t.Errorf("got condition %q; want %q", condition, tt.wantCondition)
+			}
+			if order != tt.wantOrder {
+				t.Errorf("got order %q; want %q", order, tt.wantOrder)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("got args %v; want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestCursorFor(t *testing.T) {
+	f := Filters{Sort: "-year", SortSafelist: []string{"-year"}}
+
+	c, err := decodeCursor(f.cursorFor([]any{int32(2008), int64(7)}, false))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := cursor{Sort: "-year", Values: []string{"2008", "7"}}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("got %+v; want %+v", c, want)
+	}
+
+	f.Cursor = f.cursorFor([]any{int32(2008), int64(7)}, true)
+	if !f.pagingBackwards() {
+		t.Error("got a forwards cursor; want a backwards one")
+	}
+}
+
+func TestValidateFiltersSort(t *testing.T) {
+	safelist := []string{"id", "title", "year", "-id", "-title", "-year"}
+
+	tests := []struct {
+		name       string
+		sort       string
+		cursor     string
+		wantErrors map[string]string
+	}{
+		{
+			name: "Single key",
+			sort: "-year",
+		},
+		{
+			name: "Several keys",
+			sort: "-year,title,id",
+		},
+		{
+			name:       "Unknown key",
+			sort:       "year,rating",
+			wantErrors: map[string]string{"sort": "invalid sort values"},
+		},
+		{
+			name:       "Empty key",
+			sort:       "year,",
+			wantErrors: map[string]string{"sort": "invalid sort values"},
+		},
+		{
+			name:       "Same column twice",
+			sort:       "year,-year",
+			wantErrors: map[string]string{"sort": "must not sort by the same column more than once"},
+		},
+		{
+			name:       "Too many keys",
+			sort:       "year,title,id,-year",
+			wantErrors: map[string]string{"sort": "must not contain more than 3 values"},
+		},
+		{
+			name:   "Cursor for the same sort",
+			sort:   "-year,title",
+			cursor: cursor{Sort: "-year,title", Values: []string{"2008", "Heat", "7"}}.encode(),
+		},
+		{
+			name:       "Cursor for another sort",
+			sort:       "title",
+			cursor:     cursor{Sort: "-year", Values: []string{"2008", "7"}}.encode(),
+			wantErrors: map[string]string{"cursor": "must be used with the same sort as the request it was returned by"},
+		},
+		{
+			name:       "Cursor with the wrong number of values",
+			sort:       "-year",
+			cursor:     cursor{Sort: "-year", Values: []string{"2008"}}.encode(),
+			wantErrors: map[string]string{"cursor": "must be a cursor returned by a previous request"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateFilters(v, Filters{Page: 1, PageSize: 20, Sort: tt.sort, SortSafelist: safelist, Cursor: tt.cursor})
+
+			if len(v.Errors) != len(tt.wantErrors) || (len(tt.wantErrors) > 0 && !reflect.DeepEqual(v.Errors, tt.wantErrors)) {
+				t.Errorf("got errors %v; want %v", v.Errors, tt.wantErrors)
+			}
+		})
+	}
+}
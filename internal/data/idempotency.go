@@ -0,0 +1,159 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// An IdempotencyKey records a request which was made with an Idempotency-Key header, so
+// that retries of it can be answered with the same response. Keys belong to the user who
+// made the request, so different users can use the same key without colliding. Until the
+// first request has finished, Status is 0 and there is no response.
+type IdempotencyKey struct {
+	UserID      int64
+	Key         string
+	CreatedAt   time.Time
+	Fingerprint []byte
+	Status      int
+	Headers     map[string][]string
+	Body        []byte
+}
+
+// The time after which a key whose request never finished (because the server stopped
+// part way through it, say) is treated as abandoned, and can be used again. This is
+// well beyond the server's write timeout.
+const idempotencyLockTimeout = time.Minute
+
+// Define an IdempotencyKeyModel struct type which wraps a sql.DB connection pool.
+type IdempotencyKeyModel struct {
+	DB *sql.DB
+}
+
+// Reserve records the user's key for a new request with the given fingerprint. If the
+// key is already in use then the existing record is returned instead, and the request
+// shouldn't go ahead; otherwise the returned record is nil. Keys older than the ttl have
+// expired, and are replaced as if they didn't exist.
+func (m IdempotencyKeyModel) Reserve(userID int64, key string, fingerprint []byte, ttl time.Duration) (*IdempotencyKey, error) {
+	// Insert the key, or take over an existing one if it has expired or been abandoned.
+	// The WHERE clause on the conflict means that nothing is returned if there is a
+	// current record for the key, and the row lock taken by the insert means that only
+	// one of several concurrent requests with the same key can reserve it.
+	query := `
+	INSERT INTO idempotency_keys (user_id, key, fingerprint)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, key) DO UPDATE
+	SET created_at = NOW(), fingerprint = EXCLUDED.fingerprint, status = NULL, headers = NULL, body = NULL
+	WHERE idempotency_keys.created_at < NOW() - make_interval(secs => $4)
+	OR (idempotency_keys.status IS NULL AND idempotency_keys.created_at < NOW() - make_interval(secs => $5))
+	RETURNING key`
+
+	args := []any{userID, key, fingerprint, ttl.Seconds(), idempotencyLockTimeout.Seconds()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var reserved string
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&reserved)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// The key is in use, so fetch the existing record.
+	query = `
+	SELECT user_id, key, created_at, fingerprint, COALESCE(status, 0), headers, body
+	FROM idempotency_keys
+	WHERE user_id = $1 AND key = $2`
+
+	var existing IdempotencyKey
+	var headers []byte
+
+	err = m.DB.QueryRowContext(ctx, query, userID, key).Scan(
+		&existing.UserID,
+		&existing.Key,
+		&existing.CreatedAt,
+		&existing.Fingerprint,
+		&existing.Status,
+		&headers,
+		&existing.Body,
+	)
+	if err != nil {
+		switch {
+		// The record was deleted between the two queries, because the first request
+		// failed and released it. The client can try again.
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	if headers != nil {
+		err = json.Unmarshal(headers, &existing.Headers)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &existing, nil
+}
+
+// Complete stores the response to the request which reserved a user's key, so that it can
+// be replayed to retries.
+func (m IdempotencyKeyModel) Complete(userID int64, key string, status int, headers map[string][]string, body []byte) error {
+	js, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE idempotency_keys
+	SET status = $1, headers = $2, body = $3
+	WHERE user_id = $4 AND key = $5`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Pass the headers as a string, as pq sends []byte values in the binary format,
+	// which PostgreSQL won't accept for a jsonb column.
+	_, err = m.DB.ExecContext(ctx, query, status, string(js), body, userID, key)
+	return err
+}
+
+// Release deletes a user's key which was reserved by a request which then failed, so that
+// the request can be retried.
+func (m IdempotencyKeyModel) Release(userID int64, key string) error {
+	query := `
+	DELETE FROM idempotency_keys
+	WHERE user_id = $1 AND key = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, key)
+	return err
+}
+
+// DeleteExpired deletes the keys which are older than the ttl, returning the number which
+// were deleted.
+func (m IdempotencyKeyModel) DeleteExpired(ttl time.Duration) (int64, error) {
+	query := `
+	DELETE FROM idempotency_keys
+	WHERE created_at < NOW() - make_interval(secs => $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, ttl.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,227 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.example.com/internal/validator"
+)
+
+// MergeStrategySafelist holds the supported strategies for choosing the fields of the
+// surviving movie when one movie is merged into another:
+//
+//   - keep_target keeps all of the target movie's fields as they are.
+//   - union_genres keeps the target's fields, but adds any genres from the source movie
+//     which the target is missing.
+var MergeStrategySafelist = []string{"keep_target", "union_genres"}
+
+func ValidateMerge(v *validator.Validator, target *Movie, sourceID int64, strategy string) {
+	v.Check(sourceID > 0, "source_id", "must be provided")
+	v.Check(sourceID != target.ID, "source_id", "must not be the same as the target movie")
+
+	v.Check(validator.PermittedValue(strategy, MergeStrategySafelist...), "strategy", "invalid merge strategy")
+}
+
+// MergeMovies applies the given strategy, updating the fields of the target movie with
+// values from the source movie as appropriate. The result should be checked with
+// ValidateMovie() before it is saved, as unioning the genres may exceed the limit.
+func MergeMovies(target, source *Movie, strategy string) {
+	switch strategy {
+	case "union_genres":
+		for _, genre := range source.Genres {
+			if !slices.Contains(target.Genres, genre) {
+				target.Genres = append(target.Genres, genre)
+			}
+		}
+	}
+}
+
+// Merge folds the source movie into the target movie in a single transaction. It saves
+// the target's (already merged) fields, repoints every row which refers to the source
+// movie at the target, records the merge along with a snapshot of the source movie,
+// leaves a redirect from the source's ID to the target, and finally deletes the source.
+// Both movies are checked against the version numbers they were read with, and an
+// ErrEditConflict error is returned if either has changed in the meantime.
+//
+// Any new table with a movie_id column must also be repointed here, otherwise its rows
+// will be deleted along with the source movie (or block the delete, if the foreign key
+// doesn't cascade).
+func (m *MovieModel) Merge(target, source *Movie, strategy string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	query := `
+	UPDATE movies
+	SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1, updated_at = NOW()
+	WHERE id = $5 AND version = $6
+	RETURNING version`
+
+	args := []any{
+		target.Title,
+		target.Year,
+		target.Runtime,
+		pq.Array(target.Genres),
+		target.ID,
+		target.Version,
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&target.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	// Repoint the dependent rows. Translations are keyed by movie and locale, so the
+	// target keeps its own translation wherever both movies have one for the same locale.
+	statements := []string{
+		`UPDATE movie_translations SET movie_id = $1
+		WHERE movie_id = $2
+		AND locale NOT IN (SELECT locale FROM movie_translations WHERE movie_id = $1)`,
+
+		// A movie has at most one ID from each source, so where both movies have one from
+		// the same source the target keeps its own. The source's ID is then deleted along
+		// with the source movie, and kept in the merge's snapshot.
+		`UPDATE external_ids SET movie_id = $1
+		WHERE movie_id = $2
+		AND source NOT IN (SELECT source FROM external_ids WHERE movie_id = $1)`,
+
+		`INSERT INTO movies_tags (movie_id, tag_id, created_at)
+		SELECT $1, tag_id, created_at FROM movies_tags WHERE movie_id = $2
+		ON CONFLICT DO NOTHING`,
+
+		`UPDATE releases SET movie_id = $1
+		WHERE movie_id = $2
+		AND NOT EXISTS (
+			SELECT 1 FROM releases r
+			WHERE r.movie_id = $1
+			AND r.country = releases.country
+			AND r.type = releases.type
+			AND r.release_date = releases.release_date)`,
+
+		// Where both movies are in the same collection, the target keeps its position, and
+		// the collection is renumbered once the source has been deleted.
+		`UPDATE collections_movies SET movie_id = $1
+		WHERE movie_id = $2
+		AND collection_id NOT IN (SELECT collection_id FROM collections_movies WHERE movie_id = $1)`,
+
+		// Redirects left by earlier merges into the source movie now lead to the target.
+		`UPDATE movie_redirects SET movie_id = $1 WHERE movie_id = $2`,
+
+		`INSERT INTO movie_redirects (old_id, movie_id) VALUES ($2, $1)`,
+	}
+
+	for _, statement := range statements {
+		_, err = tx.ExecContext(ctx, statement, target.ID, source.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Snapshot the source movie, along with any external IDs which weren't moved to the
+	// target, so that they aren't lost.
+	query = `
+	INSERT INTO movie_merges (source_id, target_id, strategy, source)
+	SELECT id, $1, $2, to_jsonb(movies.*) || jsonb_build_object(
+		'dropped_external_ids', COALESCE((
+			SELECT jsonb_object_agg(e.source, e.external_id)
+			FROM external_ids e
+			WHERE e.movie_id = movies.id), '{}'::jsonb))
+	FROM movies
+	WHERE id = $3`
+
+	_, err = tx.ExecContext(ctx, query, target.ID, strategy, source.ID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+	DELETE FROM movies
+	WHERE id = $1 AND version = $2`
+
+	result, err := tx.ExecContext(ctx, query, source.ID, source.Version)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	// Deleting the source movie removes it from any collections which the target was also
+	// in, leaving a gap in their positions. Renumber those collections from 1, keeping the
+	// existing order, and touch the movies whose position changed.
+	query = `
+	WITH renumbered AS (
+		SELECT collection_id, movie_id,
+			row_number() OVER (PARTITION BY collection_id ORDER BY position, movie_id) AS position
+		FROM collections_movies
+		WHERE collection_id IN (SELECT collection_id FROM collections_movies WHERE movie_id = $1)
+	), changed AS (
+		UPDATE collections_movies cm
+		SET position = r.position
+		FROM renumbered r
+		WHERE cm.collection_id = r.collection_id
+		AND cm.movie_id = r.movie_id
+		AND cm.position <> r.position
+		RETURNING cm.movie_id
+	)
+	UPDATE movies SET updated_at = NOW()
+	WHERE id IN (SELECT movie_id FROM changed)`
+
+	_, err = tx.ExecContext(ctx, query, target.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetRedirect returns the ID of the movie which a merged movie's ID now redirects to,
+// or ErrRecordNotFound if the ID was never merged.
+func (m *MovieModel) GetRedirect(oldID int64) (int64, error) {
+	if oldID < 1 {
+		return 0, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT movie_id
+	FROM movie_redirects
+	WHERE old_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var movieID int64
+
+	err := m.DB.QueryRowContext(ctx, query, oldID).Scan(&movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return movieID, nil
+}
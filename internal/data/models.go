@@ -14,15 +14,31 @@ var (
 
 // Create a Models struct which wraps the MovieModel.
 type Models struct {
-	Movies MovieModel
-	Users  UserModel
+	Collections  CollectionModel
+	ExternalIDs  ExternalIDModel
+	Idempotency  IdempotencyKeyModel
+	Movies       MovieModel
+	Permissions  PermissionModel
+	Releases     ReleaseModel
+	Tags         TagModel
+	Tokens       TokenModel
+	Translations TranslationModel
+	Users        UserModel
 }
 
 // For ease of use, we also add a New() method which returns a Models struct containing
 // the initialised MovieModel.
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Movies: MovieModel{DB: db},
-		Users:  UserModel{DB: db},
+		Collections:  CollectionModel{DB: db},
+		ExternalIDs:  ExternalIDModel{DB: db},
+		Idempotency:  IdempotencyKeyModel{DB: db},
+		Movies:       MovieModel{DB: db},
+		Permissions:  PermissionModel{DB: db},
+		Releases:     ReleaseModel{DB: db},
+		Tags:         TagModel{DB: db},
+		Tokens:       TokenModel{DB: db},
+		Translations: TranslationModel{DB: db},
+		Users:        UserModel{DB: db},
 	}
 }
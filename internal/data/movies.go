@@ -5,7 +5,10 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/lib/pq"
 	"greenlight.example.com/internal/validator"
@@ -14,11 +17,41 @@ import (
 type Movie struct {
 	ID        int64     `json:"id"`
 	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"` // Set by Insert(), Get() and Update() only
 	Title     string    `json:"title"`
 	Year      int32     `json:"year,omitempty"`
 	Runtime   Runtime   `json:"runtime,omitempty"`
 	Genres    []string  `json:"genres,omitempty"`
 	Version   int32     `json:"version"`
+	Overview  string    `json:"overview,omitempty"` // Only populated from a translation
+	Language  string    `json:"language,omitempty"` // Locale of the translation applied, if any
+	Upcoming  bool      `json:"-"`                  // Set by Get(): whether it has a release after today
+
+	Collections []*MovieCollection `json:"collections,omitempty"` // Only populated for a single movie
+	Relevance   float32            `json:"relevance,omitempty"`   // Only populated when searching by title
+	Highlight   string             `json:"highlight,omitempty"`   // Title with the matching words in <b> tags
+}
+
+// MovieFieldSafelist holds the fields of a movie which clients can pick from with a sparse
+// fieldset.
+var MovieFieldSafelist = []string{
+	"id", "title", "year", "runtime", "genres", "version",
+	"overview", "language", "collections", "relevance", "highlight",
+}
+
+// MovieListFieldSafelist holds the fields which clients can pick from with a sparse
+// fieldset for lists of movies. The collections are only loaded for a single movie, so
+// they can't be picked.
+var MovieListFieldSafelist = slices.DeleteFunc(slices.Clone(MovieFieldSafelist), func(field string) bool {
+	return field == "collections"
+})
+
+// Localize overwrites the movie's title and overview with the values from the given
+// translation, and records which locale they came from.
+func (m *Movie) Localize(translation *Translation) {
+	m.Title = translation.Title
+	m.Overview = translation.Overview
+	m.Language = translation.Locale
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
@@ -27,7 +60,14 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 
 	v.Check(movie.Year != 0, "year", "must be provided")
 	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
-	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
+	// Movies can be listed ahead of their release, so a movie with an upcoming release may
+	// be dated up to 10 years in the future (matching the movies_year_check constraint and
+	// the movies_upcoming_release_check trigger). Other movies can't be dated in the future.
+	if movie.Upcoming {
+		v.Check(movie.Year <= int32(time.Now().Year()+10), "year", "must not be more than 10 years in the future")
+	} else {
+		v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future unless the movie has an upcoming release")
+	}
 
 	v.Check(movie.Runtime != 0, "runtime", "must be provided")
 	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
@@ -40,7 +80,8 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 
 // Define a MovieModel struct type which wraps a sql.DB connection pool
 type MovieModel struct {
-	DB *sql.DB
+	DB           *sql.DB
+	SearchConfig string // Text search configuration for title searches, from SearchConfigSafelist
 }
 
 // Add a place holder method for inserting a new record into the movies table
@@ -50,7 +91,7 @@ func (m *MovieModel) Insert(movie *Movie) error {
 	query := `
         INSERT INTO movies (title, year, runtime, genres) 
         VALUES ($1, $2, $3, $4)
-        RETURNING id, created_at, version`
+        RETURNING id, created_at, updated_at, version`
 
 	// Create an args slice containing the values for the placeholder parameters from
 	// the movie struct. Declaring this slice immediately next to our SQL query helps to
@@ -66,8 +107,46 @@ func (m *MovieModel) Insert(movie *Movie) error {
 
 	// Use the QueryRowContext() method to execute the SQL query on our connection pool,
 	// passing in the args slice as a variadic parameter and scanning the system-
-	// generated id, created_at and version values into the movie struct.
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	// generated id, created_at, updated_at and version values into the movie struct.
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version)
+}
+
+// InsertWithReleases inserts a new movie along with its releases in a single transaction.
+// A movie dated in the future must have an upcoming release by the time the transaction
+// commits, so its releases can't be added afterwards.
+func (m *MovieModel) InsertWithReleases(movie *Movie, releases []*Release) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO movies (title, year, runtime, genres)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, updated_at, version`
+
+	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	for _, release := range releases {
+		release.MovieID = movie.ID
+
+		err = insertRelease(ctx, tx, release)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 // Add a place holder method for fetching a specific record from the movies table
@@ -82,7 +161,8 @@ func (m *MovieModel) Get(id int64) (*Movie, error) {
 
 	// Define the SQL query for retrieving the movie data.
 	query := `
-        SELECT id, created_at, title, year, runtime, genres, version
+        SELECT id, created_at, updated_at, title, year, runtime, genres, version,
+            EXISTS (SELECT 1 FROM releases WHERE movie_id = movies.id AND release_date > CURRENT_DATE)
         FROM movies
         WHERE id = $1`
 
@@ -105,11 +185,13 @@ func (m *MovieModel) Get(id int64) (*Movie, error) {
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
+		&movie.UpdatedAt,
 		&movie.Title,
 		&movie.Year,
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
 		&movie.Version,
+		&movie.Upcoming,
 	)
 
 	// Handle any errors. If there was no matching movie found, Scan() will return
@@ -135,9 +217,9 @@ func (m *MovieModel) Update(movie *Movie) error {
 	// query.  if version is not incremental, an ErrEditConflict will be returned.
 	query := `
         UPDATE movies 
-        SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
+        SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1, updated_at = NOW()
         WHERE id = $5 AND version = $6
-        RETURNING version`
+        RETURNING version, updated_at`
 
 	// Create an args slice containing the values for the placeholder parameters.
 	args := []any{
@@ -159,7 +241,7 @@ func (m *MovieModel) Update(movie *Movie) error {
 	// Execute the SQL query. If no matching row could be found, we know the movie
 	// version has changed (or the record has been deleted) and we return our custom
 	// ErrEditConflict error.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version, &movie.UpdatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -211,27 +293,296 @@ func (m *MovieModel) Delete(id int64) error {
 	return nil
 }
 
+// DeleteVersion deletes a movie only if it still has the given version, returning an
+// ErrEditConflict error if it has been updated (or deleted) since it was fetched. This
+// closes the gap between checking a client's precondition and deleting the movie.
+func (m *MovieModel) DeleteVersion(id int64, version int32) error {
+	query := `
+	DELETE FROM movies
+	WHERE id = $1 AND version = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, version)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// SearchConfigSafelist holds the PostgreSQL text search configurations which can be
+// used for title searches. The title indexes are built for a specific configuration (see
+// migration 000014), so they need rebuilding if the configuration is changed.
+var SearchConfigSafelist = []string{
+	"simple", "danish", "dutch", "english", "finnish", "french", "german", "hungarian",
+	"italian", "norwegian", "portuguese", "romanian", "russian", "spanish", "swedish", "turkish",
+}
+
+// CheckSearchIndexes reports whether the full-text indexes on the titles of movies and
+// their translations were built for the text search configuration in use. Postgres only
+// uses an expression index when the configuration in the query matches the one in the
+// index, so if they don't match then title searches fall back to scanning every movie.
+func (m *MovieModel) CheckSearchIndexes() (bool, error) {
+	query := `
+	SELECT count(*)
+	FROM pg_indexes
+	WHERE indexname IN ('movies_title_idx', 'movie_translations_title_idx')
+	AND indexdef LIKE '%to_tsvector(''' || $1 || '''::regconfig%'`
+
+	var count int
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, m.searchConfig()).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count == 2, nil
+}
+
+// Return the text search configuration for title searches, defaulting to "english". As
+// with the sort column, it is interpolated into queries rather than passed as a parameter
+// (so that the planner can match it against the title indexes), so panic if it isn't in
+// the safelist.
+func (m *MovieModel) searchConfig() string {
+	if m.SearchConfig == "" {
+		return "english"
+	}
+	if !slices.Contains(SearchConfigSafelist, m.SearchConfig) {
+		panic("unsafe search config:" + m.SearchConfig)
+	}
+	return m.SearchConfig
+}
+
+// Return the tsquery for the title filter, which matches all of the words in the title
+// parameter, treating the last word as a prefix so that results can be returned as the
+// user types. The words before the last one are in $15 and the last word is in $16 (see
+// MovieFilters.args()).
+func (m *MovieModel) titleQuery() string {
+	return m.titleQueryWith("$15", "$16")
+}
+
+// Return the tsquery for the title filter, with the words before the last one and the
+// last word in the given placeholders.
+func (m *MovieModel) titleQueryWith(leading, last string) string {
+	return fmt.Sprintf(`(CASE WHEN %[3]s = '' THEN plainto_tsquery('%[1]s', %[2]s)
+		ELSE plainto_tsquery('%[1]s', %[2]s) && to_tsquery('%[1]s', quote_literal(%[3]s) || ':*') END)`,
+		m.searchConfig(), leading, last)
+}
+
+// HighlightTitles highlights the words in each of the given titles which match the title
+// filter, in the same way as the highlight column of GetAll(). It is used for titles
+// which were swapped for a translation after the movies were fetched, so that the
+// highlight matches the title which is sent.
+func (m *MovieModel) HighlightTitles(movieFilters MovieFilters, titles []string) ([]string, error) {
+	if len(titles) == 0 || movieFilters.Title == "" {
+		return make([]string, len(titles)), nil
+	}
+
+	leading, last := movieFilters.titleSearchTerms()
+
+	query := fmt.Sprintf(`
+	SELECT ts_headline('%s', t.title, %s, 'HighlightAll=true')
+	FROM unnest($3::text[]) WITH ORDINALITY AS t(title, n)
+	ORDER BY t.n`, m.searchConfig(), m.titleQueryWith("$1", "$2"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, leading, last, pq.Array(titles))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	highlights := make([]string, 0, len(titles))
+
+	for rows.Next() {
+		var highlight string
+
+		err := rows.Scan(&highlight)
+		if err != nil {
+			return nil, err
+		}
+
+		highlights = append(highlights, highlight)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return highlights, nil
+}
+
+// Return the expression for the relevance of a movie to the title filter, which is the
+// best rank of its original title or any of its translations, or 0 without a title.
+func (m *MovieModel) relevance() string {
+	return fmt.Sprintf(`(CASE WHEN $1 = '' THEN 0::real ELSE GREATEST(
+		ts_rank(to_tsvector('%[1]s', title), %[2]s),
+		COALESCE((
+			SELECT max(ts_rank(to_tsvector('%[1]s', t.title), %[2]s))
+			FROM movie_translations t
+			WHERE t.movie_id = movies.id), 0)) END)`,
+		m.searchConfig(), m.titleQuery())
+}
+
+// The filtersCondition() method returns the WHERE clause which applies a MovieFilters
+// struct to the movies table, along with the values for its placeholders. It is shared by
+// GetAll(), GetFacets() and GetStats() so that facet counts and statistics are always
+// computed over exactly the same set of movies as the list itself. Callers which need
+// placeholders of their own should number them from len(args)+1.
+//
+// The fixed filters use placeholders $1 to $16 for the values returned by the args()
+// method, and the terms of the structured search query (if any) follow on from there.
+//
+// The title filter matches either the original title or any of its translations, and
+// like genres, movies must have all of the given tags to match. The release filters are
+// based on the date of each movie's first release, in any country. Zero values and
+// empty arrays disable their filters.
+func (m *MovieModel) filtersCondition(movieFilters MovieFilters) (string, []any) {
+	args := movieFilters.args()
+
+	queryCondition, queryArgs := m.compileSearchQuery(movieFilters.Query, len(args)+1)
+
+	condition := fmt.Sprintf(`
+	(to_tsvector('%[1]s', title) @@ %[2]s
+		OR EXISTS (
+			SELECT 1 FROM movie_translations t
+			WHERE t.movie_id = movies.id
+			AND to_tsvector('%[1]s', t.title) @@ %[2]s)
+		OR $1 = '')
+	AND (genres @> $2 or $2 = '{}')
+	AND (id IN (
+			SELECT mt.movie_id
+			FROM movies_tags mt
+			INNER JOIN tags t ON t.id = mt.tag_id
+			WHERE t.name = ANY($3)
+			GROUP BY mt.movie_id
+			HAVING count(*) = cardinality($3::text[]))
+		OR $3 = '{}')
+	AND (id IN (SELECT movie_id FROM collections_movies WHERE collection_id = $4) OR $4 = 0)
+	AND (NOT $5::boolean OR (SELECT min(release_date) FROM releases WHERE movie_id = movies.id) > CURRENT_DATE)
+	AND ($6::date IS NULL OR (SELECT min(release_date) FROM releases WHERE movie_id = movies.id) >= $6::date)
+	AND ($7::date IS NULL OR (SELECT min(release_date) FROM releases WHERE movie_id = movies.id) <= $7::date)
+	AND (year >= $8 OR $8 = 0)
+	AND (year <= $9 OR $9 = 0)
+	AND (runtime >= $10 OR $10 = 0)
+	AND (runtime <= $11 OR $11 = 0)
+	AND (genres && $12 OR $12 = '{}')
+	AND (NOT (genres && $13) OR $13 = '{}')
+	AND (id = ANY($14) OR $14 = '{}')
+	%[3]s`, m.searchConfig(), m.titleQuery(), queryCondition)
+
+	return condition, append(args, queryArgs...)
+}
+
+// Return the values for the fixed placeholders in filtersCondition(), in order. The words of
+// the title are split out by titleSearchTerms() so that the last one can be matched as a
+// prefix. Only letters and digits make up words, so the last word never needs escaping in
+// the tsquery.
+func (f MovieFilters) args() []any {
+	leading, last := f.titleSearchTerms()
+
+	return []any{
+		f.Title,
+		pq.Array(f.Genres),
+		pq.Array(f.Tags),
+		f.CollectionID,
+		f.Upcoming,
+		nullDate(f.ReleasedAfter),
+		nullDate(f.ReleasedBefore),
+		f.YearMin,
+		f.YearMax,
+		f.RuntimeMin,
+		f.RuntimeMax,
+		pq.Array(f.GenresAny),
+		pq.Array(f.ExcludeGenres),
+		pq.Array(f.IDs),
+		leading,
+		last,
+	}
+}
+
+// titleSearchTerms() splits the title filter into its words, returning the words before
+// the last one (joined with spaces) and the last word, which is matched as a prefix. Both
+// are empty if the filter has no words.
+func (f MovieFilters) titleSearchTerms() (leading, last string) {
+	words := strings.FieldsFunc(f.Title, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	if len(words) > 0 {
+		leading, last = strings.Join(words[:len(words)-1], " "), words[len(words)-1]
+	}
+
+	return leading, last
+}
+
 // Add a GetAll() holder method that returns a slice of movies, accepting a variety of
 // filter parameters, including pagination support
 // Update the function to return a Metadata struct.
-func (m *MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+//
+// The "relevance" sort orders the movies by how well they match the title filter, best
+// first. It is computed from the negated rank so that it sorts ascending like the other
+// columns. If highlight is true, the matching words in each title are highlighted.
+//
+// If fields is not empty, then only the columns needed for those fields are selected,
+// along with the id and sort columns which are always needed for pagination.
+func (m *MovieModel) GetAll(movieFilters MovieFilters, filters Filters, fields []string, highlight bool) ([]*Movie, Metadata, error) {
+	// When paging with a cursor, the keyset condition picks up where the cursor left off.
+	condition, args := m.filtersCondition(movieFilters)
+
+	relevance := m.relevance()
+	keysetCondition, orderBy, keysetArgs := filters.keyset(len(args)+3, map[string]string{"relevance": "-" + relevance})
+
+	// Work out which columns to select, and where to scan each of them.
+	columns := m.listColumns(fields, filters.sortColumns(), highlight)
+
+	selects := make([]string, len(columns))
+	for i, column := range columns {
+		selects[i] = column.expression
+	}
+
 	// Construct the SQL query to retrieve all movie records.  Includes 'optional' filter parameters.
+	// All of the user input is passed in through placeholders - only the validated sort
+	// column, selected columns, search configuration and keyset condition are interpolated.
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+	SELECT count(*) OVER(), %s
 	FROM movies
-	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple',$1) OR $1 = '')
-	AND (genres @> $2 or $2 = '{}')
-	ORDER BY %s %s, id ASC
-	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+	WHERE %s
+	%s
+	ORDER BY %s
+	LIMIT $%d OFFSET $%d`, strings.Join(selects, ", "), condition, keysetCondition, orderBy, len(args)+1, len(args)+2)
 
 	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	// When paging with a cursor there's no offset, and we fetch one extra row so that we
+	// can tell whether there are any more rows beyond this page.
+	limit, offset := filters.limit(), filters.offset()
+	if filters.Cursor != "" {
+		limit, offset = filters.limit()+1, 0
+	}
+
 	// As our SQL query now has a number of placeholder parameters, lets collect the
 	// values for the placeholders in a slice. Notice here how we are calling the limit()
 	// and offset() methods on Filters to get values for LIMIT and OFFSET clauses.
-	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
+	args = append(args, limit, offset)
+	args = append(args, keysetArgs...)
 
 	// Use QueryContext() to execute the query.  This returns a sql.Rows resultset
 	// containing the result. Pass the title and genres as placeholder parameter
@@ -256,18 +607,14 @@ func (m *MovieModel) GetAll(title string, genres []string, filters Filters) ([]*
 		//Initialise an empty struct to hold the data for an individual movie.
 		var movie Movie
 
-		// Scan the values from the row into the Movie struct.  Again note that we're
-		// using the pq.Array() adapter on the genres field here.
-		err := rows.Scan(
-			&totalRecords, // scan the count from the sql window function
-			&movie.ID,
-			&movie.CreatedAt,
-			&movie.Title,
-			&movie.Year,
-			&movie.Runtime,
-			pq.Array(&movie.Genres),
-			&movie.Version,
-		)
+		// Scan the values from the row into the Movie struct, starting with the count from
+		// the sql window function.
+		dest := []any{&totalRecords}
+		for _, column := range columns {
+			dest = append(dest, column.dest(&movie))
+		}
+
+		err := rows.Scan(dest...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -283,8 +630,202 @@ func (m *MovieModel) GetAll(title string, genres []string, filters Filters) ([]*
 
 	// Generate a Metadata struct, passing in the total record count and pagination
 	// parameters from the client
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	var metadata Metadata
+	var hasPrev, hasNext bool
+
+	if filters.Cursor == "" {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+		hasPrev, hasNext = filters.Page > 1, filters.Page < metadata.LastPage
+	} else {
+		// The total is meaningless once a keyset condition has been applied, so only
+		// report the page size and cursors.
+		metadata = Metadata{PageSize: filters.PageSize}
+
+		hasMore := len(movies) > filters.limit()
+		if hasMore {
+			movies = movies[:filters.limit()]
+		}
+
+		// When paging forwards, there are always rows before this page (the ones the
+		// cursor came from), and vice versa.
+		if filters.pagingBackwards() {
+			slices.Reverse(movies)
+			hasPrev, hasNext = hasMore, true
+		} else {
+			hasPrev, hasNext = true, hasMore
+		}
+	}
+
+	if len(movies) > 0 {
+		first, last := movies[0], movies[len(movies)-1]
+		columns := filters.sortColumns()
+
+		if hasPrev {
+			metadata.PrevCursor = filters.cursorFor(first.sortValues(columns), true)
+		}
+		if hasNext {
+			metadata.NextCursor = filters.cursorFor(last.sortValues(columns), false)
+		}
+	}
 
 	// If everything went OK, then return the slice of movies and the metadata struct.
 	return movies, metadata, nil
 }
+
+// A movieColumn is a column which GetAll() can select, along with the field it is for and
+// where to scan it in a Movie.
+type movieColumn struct {
+	field      string
+	expression string
+	dest       func(movie *Movie) any
+}
+
+// Return the columns for GetAll() to select for the given fields, or for all fields if
+// none are given. The sort columns (which always include id) are always included so that cursors can be
+// created. The highlight column is only included if highlighting was requested, and the
+// whole of each title is highlighted, rather than just a fragment of it.
+func (m *MovieModel) listColumns(fields []string, sortColumns []string, highlight bool) []movieColumn {
+	all := []movieColumn{
+		{"id", "id", func(movie *Movie) any { return &movie.ID }},
+		{"created_at", "created_at", func(movie *Movie) any { return &movie.CreatedAt }},
+		{"title", "title", func(movie *Movie) any { return &movie.Title }},
+		{"year", "year", func(movie *Movie) any { return &movie.Year }},
+		{"runtime", "runtime", func(movie *Movie) any { return &movie.Runtime }},
+		{"genres", "genres", func(movie *Movie) any { return pq.Array(&movie.Genres) }},
+		{"version", "version", func(movie *Movie) any { return &movie.Version }},
+		{"relevance", m.relevance(), func(movie *Movie) any { return &movie.Relevance }},
+	}
+
+	if highlight {
+		all = append(all, movieColumn{
+			"highlight",
+			fmt.Sprintf(`CASE WHEN $1 <> '' THEN ts_headline('%s', title, %s, 'HighlightAll=true') ELSE '' END`,
+				m.searchConfig(), m.titleQuery()),
+			func(movie *Movie) any { return &movie.Highlight },
+		})
+	}
+
+	if len(fields) == 0 {
+		return all
+	}
+
+	var columns []movieColumn
+	for _, column := range all {
+		if slices.Contains(sortColumns, column.field) || slices.Contains(fields, column.field) {
+			columns = append(columns, column)
+		}
+	}
+
+	return columns
+}
+
+// Return the values of the movie's fields for the given sort columns, for use in cursors.
+func (m *Movie) sortValues(columns []string) []any {
+	values := make([]any, len(columns))
+	for i, column := range columns {
+		values[i] = m.sortValue(column)
+	}
+	return values
+}
+
+// Return the value of the movie's field for the given sort column.
+func (m *Movie) sortValue(column string) any {
+	switch column {
+	case "title":
+		return m.Title
+	case "year":
+		return m.Year
+	case "runtime":
+		return int32(m.Runtime)
+	case "relevance":
+		return -m.Relevance
+	default:
+		return m.ID
+	}
+}
+
+// A SimilarMovie is a movie returned by GetSimilar(), along with a score between 0 and
+// 1 indicating how closely it resembles the movie it was compared against.
+type SimilarMovie struct {
+	*Movie
+	Score float64 `json:"score"`
+}
+
+// Define the weights given to each signal when scoring similar movies. They must add up
+// to 1 so that the resulting score stays between 0 and 1. Co-rating signals will get a
+// weight of their own once users are able to rate movies.
+const (
+	similarGenreWeight = 0.7
+	similarYearWeight  = 0.3
+)
+
+// GetSimilar returns a page of other movies ranked by their similarity to the given
+// movie. The score combines the Jaccard index of the two genre sets (the size of their
+// intersection divided by the size of their union) with the proximity of their release
+// years, which decays as 1/(1 + |difference|/10). Only movies sharing at least one genre
+// are considered; because the target genres are passed in as a parameter, the && overlap
+// check can make use of the GIN index on the genres column.
+func (m *MovieModel) GetSimilar(movie *Movie, filters Filters) ([]*SimilarMovie, Metadata, error) {
+	query := `
+	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version,
+		round((
+			$3::float8 * cardinality(ARRAY(SELECT unnest(genres) INTERSECT SELECT unnest($1::text[])))::float8
+				/ cardinality(ARRAY(SELECT unnest(genres) UNION SELECT unnest($1::text[])))
+			+ $4::float8 / (1 + abs(year - $5::integer) / 10.0)
+		)::numeric, 4)::float8 AS score
+	FROM movies
+	WHERE genres && $1 AND id <> $2
+	ORDER BY score DESC, id ASC
+	LIMIT $6 OFFSET $7`
+
+	args := []any{
+		pq.Array(movie.Genres),
+		movie.ID,
+		similarGenreWeight,
+		similarYearWeight,
+		movie.Year,
+		filters.limit(),
+		filters.offset(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*SimilarMovie{}
+
+	for rows.Next() {
+		similar := SimilarMovie{Movie: &Movie{}}
+
+		err := rows.Scan(
+			&totalRecords,
+			&similar.ID,
+			&similar.CreatedAt,
+			&similar.Title,
+			&similar.Year,
+			&similar.Runtime,
+			pq.Array(&similar.Genres),
+			&similar.Version,
+			&similar.Score,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &similar)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
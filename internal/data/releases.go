@@ -0,0 +1,186 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"golang.org/x/text/language"
+	"greenlight.example.com/internal/validator"
+)
+
+// Define a custom ErrDuplicateRelease error, returned when a movie already has a release
+// of the same type on the same date in the same country, and ErrUpcomingReleaseRequired,
+// returned when deleting the only upcoming release of a movie dated in the future.
+var (
+	ErrDuplicateRelease        = errors.New("duplicate release")
+	ErrUpcomingReleaseRequired = errors.New("upcoming release required")
+)
+
+var (
+	// ReleaseTypeSafelist holds the supported types of release.
+	ReleaseTypeSafelist = []string{"theatrical", "digital", "physical", "tv", "festival", "premiere"}
+
+	// CertificationRX sanity checks age certifications such as "PG-13", "15" or "FSK 12".
+	// Rating systems differ too much between countries to check them in more detail.
+	CertificationRX = regexp.MustCompile(`^[A-Za-z0-9+\- ]{1,12}$`)
+)
+
+// A Release records when and how a movie was (or will be) released in a given country,
+// along with the age certification it was given there. Country is an ISO 3166-1 alpha-2
+// code such as "US" or "GB".
+type Release struct {
+	ID            int64     `json:"id"`
+	CreatedAt     time.Time `json:"-"`
+	MovieID       int64     `json:"-"`
+	Country       string    `json:"country"`
+	Date          Date      `json:"date"`
+	Type          string    `json:"type"`
+	Certification string    `json:"certification,omitempty"`
+}
+
+func ValidateRelease(v *validator.Validator, release *Release) {
+	v.Check(release.Country != "", "country", "must be provided")
+	region, err := language.ParseRegion(release.Country)
+	v.Check(err == nil && region.IsCountry() && region.String() == release.Country, "country", "must be an upper case ISO 3166-1 alpha-2 country code")
+
+	v.Check(!release.Date.IsZero(), "date", "must be provided")
+	v.Check(release.Date.Year() >= 1888, "date", "must be later than 1888")
+
+	v.Check(release.Type != "", "type", "must be provided")
+	v.Check(validator.PermittedValue(release.Type, ReleaseTypeSafelist...), "type", "invalid release type")
+
+	if release.Certification != "" {
+		v.Check(validator.Matches(release.Certification, CertificationRX), "certification", "must be a valid certification")
+	}
+}
+
+// Define a ReleaseModel struct type which wraps a sql.DB connection pool.
+type ReleaseModel struct {
+	DB *sql.DB
+}
+
+// Insert a new release for a movie.
+func (m ReleaseModel) Insert(release *Release) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	err = insertRelease(ctx, tx, release)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Insert a release within a transaction, so that it can be inserted along with a new movie
+// by MovieModel.InsertWithReleases().
+func insertRelease(ctx context.Context, tx *sql.Tx, release *Release) error {
+	query := `
+	INSERT INTO releases (movie_id, country, release_date, type, certification)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`
+
+	args := []any{release.MovieID, release.Country, release.Date, release.Type, release.Certification}
+
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&release.ID, &release.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "releases_movie_country_type_date_key"`:
+			return ErrDuplicateRelease
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAllForMovie returns the releases of a specific movie, ordered by date and country.
+func (m ReleaseModel) GetAllForMovie(movieID int64) ([]*Release, error) {
+	query := `
+	SELECT id, created_at, movie_id, country, release_date, type, certification
+	FROM releases
+	WHERE movie_id = $1
+	ORDER BY release_date, country, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	releases := []*Release{}
+
+	for rows.Next() {
+		var release Release
+
+		err := rows.Scan(
+			&release.ID,
+			&release.CreatedAt,
+			&release.MovieID,
+			&release.Country,
+			&release.Date,
+			&release.Type,
+			&release.Certification,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		releases = append(releases, &release)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return releases, nil
+}
+
+// Delete a specific release of a specific movie.
+func (m ReleaseModel) Delete(movieID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM releases
+	WHERE id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, movieID)
+	if err != nil {
+		switch {
+		case err.Error() == "pq: movies dated in the future must have an upcoming release":
+			return ErrUpcomingReleaseRequired
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
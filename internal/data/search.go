@@ -0,0 +1,350 @@
+package data
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"greenlight.example.com/internal/validator"
+)
+
+// A SearchQuery is the parsed form of a structured search such as:
+//
+//	year:>=2000 genre:drama -tag:"found footage" runtime:<120 "dark knight"
+//
+// It is made up of terms which must all match. Terms can be negated with a leading "-",
+// and are either field terms (field:value, with an optional comparison operator before
+// the value) or text terms, which are bare words or quoted phrases matched against the
+// title.
+type SearchQuery struct {
+	Terms []SearchTerm
+}
+
+// A SearchTerm is a single term in a SearchQuery: either a *FieldTerm or a *TextTerm.
+type SearchTerm interface {
+	Position() int
+}
+
+// A FieldTerm compares a field with a value, such as year:>=2000. Op is one of "=", ">",
+// ">=", "<" or "<=", and is "=" if no operator was given. Pos is the position of the
+// term in the query, counting from 1.
+type FieldTerm struct {
+	Pos     int
+	Negated bool
+	Field   string
+	Op      string
+	Value   string
+}
+
+func (t *FieldTerm) Position() int { return t.Pos }
+
+// A TextTerm is a bare word, or a quoted phrase whose words must appear together and in
+// order, which is matched against the title.
+type TextTerm struct {
+	Pos     int
+	Negated bool
+	Text    string
+	Phrase  bool
+}
+
+func (t *TextTerm) Position() int { return t.Pos }
+
+// SearchFieldSafelist holds the fields which can be used in field terms, mapped to the
+// comparison operators which they support.
+var SearchFieldSafelist = map[string][]string{
+	"year":    {"=", ">", ">=", "<", "<="},
+	"runtime": {"=", ">", ">=", "<", "<="},
+	"genre":   {"="},
+	"tag":     {"="},
+	"title":   {"="},
+}
+
+// A SearchSyntaxError is returned by ParseSearchQuery() when a query can't be parsed. Pos
+// is the position of the problem in the query, counting from 1.
+type SearchSyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SearchSyntaxError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+// ParseSearchQuery parses a structured search query into a SearchQuery. Positions are
+// counted in characters rather than bytes, so that they line up with what the user typed.
+func ParseSearchQuery(s string) (*SearchQuery, error) {
+	p := searchParser{input: []rune(s)}
+	query := &SearchQuery{}
+
+	for {
+		p.skipSpace()
+		if p.done() {
+			return query, nil
+		}
+
+		term, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+
+		query.Terms = append(query.Terms, term)
+	}
+}
+
+// A searchParser is a simple recursive descent parser for the search query grammar:
+//
+//	query   = { term }
+//	term    = [ "-" ] ( field ":" [ op ] value | value )
+//	field   = a letter followed by letters or underscores, in any case
+//	op      = ">=" | "<=" | ">" | "<" | "="
+//	value   = phrase | word
+//	phrase  = '"' { any character except '"' } '"'
+//	word    = any characters up to the next space
+type searchParser struct {
+	input []rune
+	pos   int // Index of the next rune to read
+}
+
+func (p *searchParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *searchParser) peek() rune {
+	if p.done() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *searchParser) skipSpace() {
+	for !p.done() && unicode.IsSpace(p.peek()) {
+		p.pos++
+	}
+}
+
+func (p *searchParser) errorf(pos int, format string, args ...any) error {
+	return &SearchSyntaxError{Pos: pos + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *searchParser) parseTerm() (SearchTerm, error) {
+	start := p.pos
+
+	negated := false
+	if p.peek() == '-' && p.pos+1 < len(p.input) && !unicode.IsSpace(p.input[p.pos+1]) {
+		negated = true
+		p.pos++
+	}
+
+	// Look ahead for a field name followed by a colon. A colon followed by a space (or
+	// the end of the query) doesn't start a field term, so that titles like `Star Trek:
+	// Nemesis` can be searched for without quotes; in that case rewind and read the term
+	// as text. Otherwise the term is a field term, even if the field is unknown, so that
+	// ValidateSearchQuery() can report typos like `yeer:2000` rather than them quietly
+	// becoming a title search.
+	fieldStart := p.pos
+	for !p.done() && (unicode.IsLetter(p.peek()) || (p.pos > fieldStart && p.peek() == '_')) {
+		p.pos++
+	}
+
+	if p.pos > fieldStart && p.peek() == ':' && p.pos+1 < len(p.input) && !unicode.IsSpace(p.input[p.pos+1]) {
+		field := strings.ToLower(string(p.input[fieldStart:p.pos]))
+		p.pos++
+
+		op := p.parseOp()
+
+		if p.done() || unicode.IsSpace(p.peek()) {
+			return nil, p.errorf(p.pos, "expected a value for %q", field)
+		}
+
+		value, _, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		return &FieldTerm{Pos: start + 1, Negated: negated, Field: field, Op: op, Value: value}, nil
+	}
+
+	p.pos = fieldStart
+
+	text, phrase, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TextTerm{Pos: start + 1, Negated: negated, Text: text, Phrase: phrase}, nil
+}
+
+func (p *searchParser) parseOp() string {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(string(p.input[p.pos:min(p.pos+2, len(p.input))]), op) {
+			p.pos += len(op)
+			return op
+		}
+	}
+	return "="
+}
+
+// Parse a quoted phrase or a bare word, returning its text and whether it was quoted.
+func (p *searchParser) parseValue() (string, bool, error) {
+	start := p.pos
+
+	if p.peek() == '"' {
+		p.pos++
+		for !p.done() && p.peek() != '"' {
+			p.pos++
+		}
+		if p.done() {
+			return "", false, p.errorf(start, "unterminated quoted phrase")
+		}
+
+		text := string(p.input[start+1 : p.pos])
+		p.pos++
+
+		if strings.TrimSpace(text) == "" {
+			return "", false, p.errorf(start, "empty quoted phrase")
+		}
+		if !p.done() && !unicode.IsSpace(p.peek()) {
+			return "", false, p.errorf(p.pos, "expected a space after the closing quote")
+		}
+
+		return text, true, nil
+	}
+
+	for !p.done() && !unicode.IsSpace(p.peek()) {
+		if p.peek() == '"' {
+			return "", false, p.errorf(p.pos, "unexpected quote")
+		}
+		p.pos++
+	}
+
+	return string(p.input[start:p.pos]), false, nil
+}
+
+// ValidateSearchQuery checks each term of a parsed search query, recording the first
+// problem found (along with its position) against the "q" key.
+func ValidateSearchQuery(v *validator.Validator, query *SearchQuery) {
+	v.Check(len(query.Terms) <= 20, "q", "must not contain more than 20 terms")
+
+	maxYear := time.Now().Year() + 10
+
+	for _, term := range query.Terms {
+		fieldTerm, ok := term.(*FieldTerm)
+		if !ok {
+			continue
+		}
+
+		check := func(ok bool, message string) {
+			v.Check(ok, "q", fmt.Sprintf("%s at position %d", message, fieldTerm.Pos))
+		}
+
+		ops, known := SearchFieldSafelist[fieldTerm.Field]
+		check(known, fmt.Sprintf("unknown field %q", fieldTerm.Field))
+		if !known {
+			continue
+		}
+
+		check(slices.Contains(ops, fieldTerm.Op), fmt.Sprintf("%q does not support the %q operator", fieldTerm.Field, fieldTerm.Op))
+
+		switch fieldTerm.Field {
+		case "year":
+			year, err := strconv.Atoi(fieldTerm.Value)
+			check(err == nil, `"year" must be an integer`)
+			check(err != nil || (year >= 1888 && year <= maxYear), fmt.Sprintf(`"year" must be between 1888 and %d`, maxYear))
+		case "runtime":
+			runtime, err := strconv.Atoi(fieldTerm.Value)
+			check(err == nil, `"runtime" must be an integer number of minutes`)
+			check(err != nil || runtime >= 0, `"runtime" must not be negative`)
+		case "tag":
+			check(NormalizeTag(fieldTerm.Value) != "", `"tag" must not be empty`)
+		}
+	}
+}
+
+// Compile a validated search query into a SQL condition, which is empty if the query has
+// no terms. The values are all passed through placeholders, numbered from the given
+// placeholder, and returned as args. Only fixed SQL and the validated fields, operators
+// and text search configuration are written into the condition itself.
+func (m *MovieModel) compileSearchQuery(query *SearchQuery, placeholder int) (string, []any) {
+	if query == nil {
+		return "", nil
+	}
+
+	var conditions []string
+	var args []any
+
+	config := m.searchConfig()
+
+	for _, term := range query.Terms {
+		var condition string
+		var negated bool
+
+		p := placeholder + len(args)
+
+		switch term := term.(type) {
+		case *FieldTerm:
+			negated = term.Negated
+
+			// As with the sort column, the query should already have been validated, so
+			// panic if the operator isn't in the safelist for the field.
+			if !slices.Contains(SearchFieldSafelist[term.Field], term.Op) {
+				panic("unsafe search operator:" + term.Op)
+			}
+
+			switch term.Field {
+			case "year", "runtime":
+				n, _ := strconv.Atoi(term.Value)
+				condition = fmt.Sprintf("%s %s $%d", term.Field, term.Op, p)
+				args = append(args, n)
+			case "genre":
+				condition = fmt.Sprintf("$%d = ANY(genres)", p)
+				args = append(args, term.Value)
+			case "tag":
+				condition = fmt.Sprintf(`id IN (
+					SELECT mt.movie_id FROM movies_tags mt
+					INNER JOIN tags t ON t.id = mt.tag_id
+					WHERE t.name = $%d)`, p)
+				args = append(args, NormalizeTag(term.Value))
+			case "title":
+				condition = titleSearchCondition(config, "plainto_tsquery", p)
+				args = append(args, term.Value)
+			default:
+				panic("unsafe search field:" + term.Field)
+			}
+		case *TextTerm:
+			negated = term.Negated
+
+			function := "plainto_tsquery"
+			if term.Phrase {
+				function = "phraseto_tsquery"
+			}
+
+			condition = titleSearchCondition(config, function, p)
+			args = append(args, term.Text)
+		}
+
+		if negated {
+			condition = "NOT (" + condition + ")"
+		}
+
+		conditions = append(conditions, "("+condition+")")
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+
+	return "AND " + strings.Join(conditions, "\n\tAND "), args
+}
+
+// Return a condition which matches the title, or any of its translations, against the
+// tsquery built by the given function from the value in the given placeholder.
+func titleSearchCondition(config, function string, placeholder int) string {
+	return fmt.Sprintf(`to_tsvector('%[1]s', title) @@ %[2]s('%[1]s', $%[3]d)
+		OR EXISTS (
+			SELECT 1 FROM movie_translations t
+			WHERE t.movie_id = movies.id
+			AND to_tsvector('%[1]s', t.title) @@ %[2]s('%[1]s', $%[3]d))`, config, function, placeholder)
+}
@@ -0,0 +1,257 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"greenlight.example.com/internal/validator"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []SearchTerm
+	}{
+		{
+			name:  "Empty",
+			query: "   ",
+			want:  nil,
+		},
+		{
+			name:  "Words",
+			query: "dark knight",
+			want: []SearchTerm{
+				&TextTerm{Pos: 1, Text: "dark"},
+				&TextTerm{Pos: 6, Text: "knight"},
+			},
+		},
+		{
+			name:  "Phrase",
+			query: `"dark knight" rises`,
+			want: []SearchTerm{
+				&TextTerm{Pos: 1, Text: "dark knight", Phrase: true},
+				&TextTerm{Pos: 15, Text: "rises"},
+			},
+		},
+		{
+			name:  "Fields with operators",
+			query: "year:>=2000 runtime:<120 genre:drama",
+			want: []SearchTerm{
+				&FieldTerm{Pos: 1, Field: "year", Op: ">=", Value: "2000"},
+				&FieldTerm{Pos: 13, Field: "runtime", Op: "<", Value: "120"},
+				&FieldTerm{Pos: 26, Field: "genre", Op: "=", Value: "drama"},
+			},
+		},
+		{
+			name:  "Field names are case-insensitive",
+			query: "YEAR:2000",
+			want: []SearchTerm{
+				&FieldTerm{Pos: 1, Field: "year", Op: "=", Value: "2000"},
+			},
+		},
+		{
+			name:  "Negated terms",
+			query: `-tag:"found footage" -sequel`,
+			want: []SearchTerm{
+				&FieldTerm{Pos: 1, Negated: true, Field: "tag", Op: "=", Value: "found footage"},
+				&TextTerm{Pos: 22, Negated: true, Text: "sequel"},
+			},
+		},
+		{
+			name:  "Lone hyphen is text",
+			query: "spider - man",
+			want: []SearchTerm{
+				&TextTerm{Pos: 1, Text: "spider"},
+				&TextTerm{Pos: 8, Text: "-"},
+				&TextTerm{Pos: 10, Text: "man"},
+			},
+		},
+		{
+			name:  "Colon before a space is text",
+			query: "Star Trek: Nemesis",
+			want: []SearchTerm{
+				&TextTerm{Pos: 1, Text: "Star"},
+				&TextTerm{Pos: 6, Text: "Trek:"},
+				&TextTerm{Pos: 12, Text: "Nemesis"},
+			},
+		},
+		{
+			name:  "Unknown field is a field term",
+			query: "director:nolan",
+			want: []SearchTerm{
+				&FieldTerm{Pos: 1, Field: "director", Op: "=", Value: "nolan"},
+			},
+		},
+		{
+			name:  "Known field without value is text",
+			query: "Title: Alien",
+			want: []SearchTerm{
+				&TextTerm{Pos: 1, Text: "Title:"},
+				&TextTerm{Pos: 8, Text: "Alien"},
+			},
+		},
+		{
+			name:  "Known field at end is text",
+			query: "alien year:",
+			want: []SearchTerm{
+				&TextTerm{Pos: 1, Text: "alien"},
+				&TextTerm{Pos: 7, Text: "year:"},
+			},
+		},
+		{
+			name:  "Positions count characters",
+			query: "amélie year:2001",
+			want: []SearchTerm{
+				&TextTerm{Pos: 1, Text: "amélie"},
+				&FieldTerm{Pos: 8, Field: "year", Op: "=", Value: "2001"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSearchQuery(tt.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got.Terms, tt.want) {
+				t.Errorf("got %s; want %s", formatTerms(got.Terms), formatTerms(tt.want))
+			}
+		})
+	}
+}
+
+func TestParseSearchQueryErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantPos int
+		wantMsg string
+	}{
+		{
+			name:    "Unterminated phrase",
+			query:   `alien "dark knight`,
+			wantPos: 7,
+			wantMsg: "unterminated quoted phrase",
+		},
+		{
+			name:    "Empty phrase",
+			query:   `alien "  "`,
+			wantPos: 7,
+			wantMsg: "empty quoted phrase",
+		},
+		{
+			name:    "No space after phrase",
+			query:   `"dark knight"rises`,
+			wantPos: 14,
+			wantMsg: "expected a space after the closing quote",
+		},
+		{
+			name:    "Quote inside word",
+			query:   `dark kni"ght`,
+			wantPos: 9,
+			wantMsg: "unexpected quote",
+		},
+		{
+			name:    "Unterminated field phrase",
+			query:   `tag:"found`,
+			wantPos: 5,
+			wantMsg: "unterminated quoted phrase",
+		},
+		{
+			name:    "Operator without value",
+			query:   "alien year:>= 2000",
+			wantPos: 14,
+			wantMsg: `expected a value for "year"`,
+		},
+		{
+			name:    "Operator at end",
+			query:   "year:<",
+			wantPos: 7,
+			wantMsg: `expected a value for "year"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSearchQuery(tt.query)
+
+			var syntaxError *SearchSyntaxError
+			if !errors.As(err, &syntaxError) {
+				t.Fatalf("got error %v; want a *SearchSyntaxError", err)
+			}
+
+			if syntaxError.Pos != tt.wantPos || syntaxError.Msg != tt.wantMsg {
+				t.Errorf("got %q at %d; want %q at %d", syntaxError.Msg, syntaxError.Pos, tt.wantMsg, tt.wantPos)
+			}
+		})
+	}
+}
+
+func TestValidateSearchQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantErr string
+	}{
+		{
+			name:  "Valid",
+			query: `year:>=2000 runtime:<=120 genre:drama tag:heist "dark knight"`,
+		},
+		{
+			name:    "Unknown field",
+			query:   "alien yeer:2000",
+			wantErr: `unknown field "yeer" at position 7`,
+		},
+		{
+			name:    "Unsupported operator",
+			query:   "alien genre:>drama",
+			wantErr: `"genre" does not support the ">" operator at position 7`,
+		},
+		{
+			name:    "Year not an integer",
+			query:   "year:recent",
+			wantErr: `"year" must be an integer at position 1`,
+		},
+		{
+			name:    "Runtime negative",
+			query:   "runtime:>-5",
+			wantErr: `"runtime" must not be negative at position 1`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := ParseSearchQuery(tt.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			v := validator.New()
+			ValidateSearchQuery(v, query)
+
+			if got := v.Errors["q"]; got != tt.wantErr {
+				t.Errorf("got error %q; want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+// formatTerms formats search terms for test failure messages.
+func formatTerms(terms []SearchTerm) string {
+	var s []string
+	for _, term := range terms {
+		switch term := term.(type) {
+		case *FieldTerm:
+			s = append(s, fmt.Sprintf("field@%d(negated=%t %s:%s%s)", term.Pos, term.Negated, term.Field, term.Op, term.Value))
+		case *TextTerm:
+			s = append(s, fmt.Sprintf("text@%d(negated=%t phrase=%t %q)", term.Pos, term.Negated, term.Phrase, term.Text))
+		}
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
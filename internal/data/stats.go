@@ -0,0 +1,122 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.example.com/internal/validator"
+)
+
+// MovieStats holds aggregate statistics about the movies matching a set of filters. The
+// runtimes are in minutes, and are zero if no movies match.
+type MovieStats struct {
+	Total          int           `json:"total"`
+	AverageRuntime float64       `json:"average_runtime"`
+	MedianRuntime  float64       `json:"median_runtime"`
+	ByYear         []*FacetCount `json:"by_year"`
+	ByDecade       []*FacetCount `json:"by_decade"`
+	ByGenre        []*FacetCount `json:"by_genre"`
+	Newest         []*Movie      `json:"newest"`
+}
+
+func ValidateStats(v *validator.Validator, newest int) {
+	v.Check(newest >= 0, "newest", "must not be negative")
+	v.Check(newest <= 20, "newest", "must be a maximum of 20")
+}
+
+// GetStats calculates statistics for the movies matching the given filters, using the
+// same filter condition as GetAll(). The counts per year, decade and genre are the same
+// as the corresponding facets, and the newest additions are the given number of most
+// recently created movies.
+func (m *MovieModel) GetStats(movieFilters MovieFilters, newest int) (*MovieStats, error) {
+	var stats MovieStats
+
+	condition, args := m.filtersCondition(movieFilters)
+
+	query := fmt.Sprintf(`
+	SELECT count(*),
+		COALESCE(round(avg(runtime), 1), 0)::float8,
+		COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY runtime), 0)::float8
+	FROM movies
+	WHERE %s`, condition)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&stats.Total,
+		&stats.AverageRuntime,
+		&stats.MedianRuntime,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	facets, err := m.GetFacets(movieFilters, []string{"year", "decade", "genres"})
+	if err != nil {
+		return nil, err
+	}
+
+	stats.ByYear, stats.ByDecade, stats.ByGenre = facets["year"], facets["decade"], facets["genres"]
+
+	stats.Newest, err = m.getNewest(movieFilters, newest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// Return up to limit of the most recently created movies matching the given filters.
+func (m *MovieModel) getNewest(movieFilters MovieFilters, limit int) ([]*Movie, error) {
+	movies := []*Movie{}
+
+	if limit == 0 {
+		return movies, nil
+	}
+
+	condition, args := m.filtersCondition(movieFilters)
+
+	query := fmt.Sprintf(`
+	SELECT id, created_at, title, year, runtime, genres, version
+	FROM movies
+	WHERE %s
+	ORDER BY created_at DESC, id DESC
+	LIMIT $%d`, condition, len(args)+1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
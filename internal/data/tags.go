@@ -0,0 +1,208 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.example.com/internal/validator"
+)
+
+// A Tag is a user-supplied label which can be attached to any number of movies. Count
+// holds the number of movies the tag is attached to.
+type Tag struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// NormalizeTag converts a tag to the form in which it is stored, so that "Time  Loop"
+// and "time loop" are treated as the same tag: it is lowercased, leading and trailing
+// whitespace is removed, and runs of internal whitespace are collapsed to a single space.
+func NormalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), " ")
+}
+
+// NormalizeTagFilter normalizes the tags in a tag filter, dropping any which are repeated
+// (or only differ in case or spacing). A movie must have every tag in the filter, so
+// repeats would otherwise stop anything from matching.
+func NormalizeTagFilter(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = NormalizeTag(tag)
+		if !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+
+	return normalized
+}
+
+// ValidateTags checks a list of tags which have already been normalized.
+func ValidateTags(v *validator.Validator, tags []string) {
+	v.Check(tags != nil, "tags", "must be provided")
+	v.Check(len(tags) >= 1, "tags", "must contain at least 1 tag")
+	v.Check(len(tags) <= 20, "tags", "must not contain more than 20 tags")
+	v.Check(validator.Unique(tags), "tags", "must not contain duplicate values")
+
+	for _, tag := range tags {
+		v.Check(tag != "", "tags", "must not contain empty tags")
+		v.Check(len(tag) <= 50, "tags", "must not contain tags more than 50 bytes long")
+		// Tags are filtered on using a comma-separated query string parameter, so they
+		// can't contain commas themselves.
+		v.Check(!strings.Contains(tag, ","), "tags", "must not contain commas")
+	}
+}
+
+// Define a TagModel struct type which wraps a sql.DB connection pool.
+type TagModel struct {
+	DB *sql.DB
+}
+
+// GetAllForMovie returns the names of the tags attached to a specific movie, in
+// alphabetical order.
+func (m TagModel) GetAllForMovie(movieID int64) ([]string, error) {
+	query := `
+	SELECT t.name
+	FROM tags t
+	INNER JOIN movies_tags mt ON mt.tag_id = t.id
+	WHERE mt.movie_id = $1
+	ORDER BY t.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+
+	for rows.Next() {
+		var tag string
+
+		err := rows.Scan(&tag)
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// AddForMovie attaches the given tags to a specific movie, creating any tags which don't
+// exist yet. Attaching a tag which is already attached is not an error.
+func (m TagModel) AddForMovie(movieID int64, tags ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO tags (name)
+	SELECT unnest($1::text[])
+	ON CONFLICT (name) DO NOTHING`
+
+	_, err = tx.ExecContext(ctx, query, pq.Array(tags))
+	if err != nil {
+		return err
+	}
+
+	query = `
+	INSERT INTO movies_tags (movie_id, tag_id)
+	SELECT $1, id FROM tags WHERE name = ANY($2)
+	ON CONFLICT DO NOTHING`
+
+	_, err = tx.ExecContext(ctx, query, movieID, pq.Array(tags))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveForMovie detaches a tag from a specific movie, returning ErrRecordNotFound if it
+// wasn't attached. The tag itself is kept, even if no movies use it any more.
+func (m TagModel) RemoveForMovie(movieID int64, tag string) error {
+	query := `
+	DELETE FROM movies_tags
+	WHERE movie_id = $1
+	AND tag_id = (SELECT id FROM tags WHERE name = $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, tag)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetPopular returns a page of the tags which are attached to at least one movie, most
+// used first.
+func (m TagModel) GetPopular(filters Filters) ([]*Tag, Metadata, error) {
+	query := `
+	SELECT count(*) OVER(), t.name, count(*) AS movies
+	FROM tags t
+	INNER JOIN movies_tags mt ON mt.tag_id = t.id
+	GROUP BY t.id
+	ORDER BY movies DESC, t.name ASC
+	LIMIT $1 OFFSET $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	tags := []*Tag{}
+
+	for rows.Next() {
+		var tag Tag
+
+		err := rows.Scan(&totalRecords, &tag.Name, &tag.Count)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		tags = append(tags, &tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return tags, metadata, nil
+}
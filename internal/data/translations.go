@@ -0,0 +1,154 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.example.com/internal/validator"
+)
+
+// A Translation holds the localized title and overview of a movie for a single locale.
+// The locale is stored as a canonical BCP 47 language tag (e.g. "en", "fr-CA").
+type Translation struct {
+	MovieID  int64  `json:"-"`
+	Locale   string `json:"locale"`
+	Title    string `json:"title"`
+	Overview string `json:"overview,omitempty"`
+	Version  int32  `json:"version"`
+}
+
+func ValidateTranslation(v *validator.Validator, translation *Translation) {
+	v.Check(translation.Locale != "", "locale", "must be provided")
+	v.Check(len(translation.Locale) <= 35, "locale", "must not be more than 35 bytes long")
+
+	v.Check(translation.Title != "", "title", "must be provided")
+	v.Check(len(translation.Title) <= 500, "title", "must not be more than 500 bytes long")
+
+	v.Check(len(translation.Overview) <= 10_000, "overview", "must not be more than 10000 bytes long")
+}
+
+// Define a TranslationModel struct type which wraps a sql.DB connection pool.
+type TranslationModel struct {
+	DB *sql.DB
+}
+
+// Upsert creates the translation for the movie and locale, or replaces the title and
+// overview of the existing one and bumps its version number. The movie's updated_at time
+// is bumped too, as the translation is part of the movie's localized representation.
+func (m TranslationModel) Upsert(translation *Translation) error {
+	query := `
+	WITH translation AS (
+		INSERT INTO movie_translations (movie_id, locale, title, overview)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (movie_id, locale) DO UPDATE
+		SET title = EXCLUDED.title, overview = EXCLUDED.overview, version = movie_translations.version + 1
+		RETURNING version
+	), touched AS (
+		UPDATE movies SET updated_at = NOW() WHERE id = $1
+	)
+	SELECT version FROM translation`
+
+	args := []any{translation.MovieID, translation.Locale, translation.Title, translation.Overview}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&translation.Version)
+}
+
+// GetAllForMovie returns every translation for a specific movie, ordered by locale.
+func (m TranslationModel) GetAllForMovie(movieID int64) ([]*Translation, error) {
+	translations, err := m.GetAllForMovies([]int64{movieID})
+	if err != nil {
+		return nil, err
+	}
+
+	return translations[movieID], nil
+}
+
+// GetAllForMovies returns the translations for a set of movies in a single query, keyed
+// by movie ID. This lets the list endpoint localize a whole page of movies without
+// issuing one query per movie.
+func (m TranslationModel) GetAllForMovies(movieIDs []int64) (map[int64][]*Translation, error) {
+	translations := make(map[int64][]*Translation)
+
+	if len(movieIDs) == 0 {
+		return translations, nil
+	}
+
+	query := `
+	SELECT movie_id, locale, title, overview, version
+	FROM movie_translations
+	WHERE movie_id = ANY($1)
+	ORDER BY movie_id, locale`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var translation Translation
+
+		err := rows.Scan(
+			&translation.MovieID,
+			&translation.Locale,
+			&translation.Title,
+			&translation.Overview,
+			&translation.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		translations[translation.MovieID] = append(translations[translation.MovieID], &translation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return translations, nil
+}
+
+// Delete removes the translation for a specific movie and locale, bumping the movie's
+// updated_at time, and returns ErrRecordNotFound if there wasn't one.
+func (m TranslationModel) Delete(movieID int64, locale string) error {
+	if movieID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	WITH deleted AS (
+		DELETE FROM movie_translations
+		WHERE movie_id = $1 AND locale = $2
+		RETURNING movie_id
+	)
+	UPDATE movies SET updated_at = NOW()
+	WHERE id IN (SELECT movie_id FROM deleted)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, locale)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
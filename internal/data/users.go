@@ -2,6 +2,7 @@ package data
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"errors"
 	"time"
@@ -15,6 +16,10 @@ var (
 	ErrDuplicateEmail = errors.New("duplicate email")
 )
 
+// Declare a new AnonymousUser variable, which represents a request from a client which
+// hasn't authenticated.
+var AnonymousUser = &User{}
+
 type User struct {
 	ID        int64     `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
@@ -25,6 +30,11 @@ type User struct {
 	Version   int       `json:"-"`
 }
 
+// Check if a User instance is the AnonymousUser.
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
 // Create a custom password type which is a struct containing the plaintext and hashed
 // versions of the password for a user.  The plaintext field is a *pointer* to a string,
 // so that we're able to distinguish between a plaintext password not being present in
@@ -208,3 +218,46 @@ func (m UserModel) Update(user *User) error {
 	}
 	return nil
 }
+
+// GetForToken retrieves the user who owns a token with the given scope and plaintext
+// value, provided that the token hasn't expired. It returns ErrRecordNotFound if there is
+// no such token.
+func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+	// Calculate the SHA-256 hash of the plaintext token provided by the client.
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	FROM users
+	INNER JOIN tokens ON users.id = tokens.user_id
+	WHERE tokens.hash = $1
+	AND tokens.scope = $2
+	AND tokens.expiry > $3`
+
+	args := []any{tokenHash[:], tokenScope, time.Now()}
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
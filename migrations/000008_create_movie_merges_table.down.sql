@@ -0,0 +1,5 @@
+ALTER TABLE external_ids ADD CONSTRAINT external_ids_movie_source_key UNIQUE (movie_id, source);
+
+DROP TABLE IF EXISTS movie_merges;
+
+DROP TABLE IF EXISTS movie_redirects;
\ No newline at end of file
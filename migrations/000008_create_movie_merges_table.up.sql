@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS movie_redirects (
+    old_id bigint PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS movie_merges (
+    id bigserial PRIMARY KEY,
+    merged_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    source_id bigint NOT NULL,
+    target_id bigint NOT NULL,
+    strategy text NOT NULL,
+    source jsonb NOT NULL
+);
+
+ALTER TABLE external_ids DROP CONSTRAINT IF EXISTS external_ids_movie_source_key;
\ No newline at end of file
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS releases (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    country text NOT NULL,
+    release_date date NOT NULL,
+    type text NOT NULL,
+    certification text NOT NULL DEFAULT '',
+    CONSTRAINT releases_type_check CHECK (type IN ('theatrical', 'digital', 'physical', 'tv', 'festival', 'premiere')),
+    CONSTRAINT releases_movie_country_type_date_key UNIQUE (movie_id, country, type, release_date)
+);
+
+CREATE INDEX IF NOT EXISTS releases_release_date_idx ON releases (release_date);
+
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_year_check;
+
+ALTER TABLE movies ADD CONSTRAINT movies_year_check CHECK (year BETWEEN 1888 AND date_part('year', now()) + 10);
\ No newline at end of file
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS movies_title_idx;
+
+CREATE INDEX IF NOT EXISTS movies_title_idx ON movies USING GIN (to_tsvector('english', title));
+
+DROP INDEX IF EXISTS movie_translations_title_idx;
+
+CREATE INDEX IF NOT EXISTS movie_translations_title_idx ON movie_translations USING GIN (to_tsvector('english', title));
\ No newline at end of file
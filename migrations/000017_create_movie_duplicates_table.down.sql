@@ -0,0 +1 @@
+DROP TABLE IF EXISTS movie_duplicates;
\ No newline at end of file
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS movie_duplicates (
+    movie_id bigint PRIMARY KEY REFERENCES movies ON DELETE CASCADE,
+    cluster_id bigint NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS movie_duplicates_cluster_id_idx ON movie_duplicates (cluster_id);
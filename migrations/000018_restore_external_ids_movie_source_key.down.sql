@@ -0,0 +1 @@
+ALTER TABLE external_ids DROP CONSTRAINT IF EXISTS external_ids_movie_source_key;
\ No newline at end of file
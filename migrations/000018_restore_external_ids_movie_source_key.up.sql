@@ -0,0 +1,7 @@
+DELETE FROM external_ids e
+USING external_ids older
+WHERE older.movie_id = e.movie_id
+AND older.source = e.source
+AND (older.created_at, older.external_id) < (e.created_at, e.external_id);
+
+ALTER TABLE external_ids ADD CONSTRAINT external_ids_movie_source_key UNIQUE (movie_id, source);
\ No newline at end of file
@@ -0,0 +1,7 @@
+DELETE FROM idempotency_keys;
+
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+
+ALTER TABLE idempotency_keys ADD COLUMN user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE;
+
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (user_id, key);
\ No newline at end of file
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS releases_upcoming_release_check ON releases;
+
+DROP TRIGGER IF EXISTS movies_upcoming_release_check ON movies;
+
+DROP FUNCTION IF EXISTS check_movie_upcoming_release();
\ No newline at end of file
@@ -0,0 +1,34 @@
+CREATE OR REPLACE FUNCTION check_movie_upcoming_release() RETURNS trigger AS $$
+DECLARE
+    checked_id bigint;
+BEGIN
+    IF TG_TABLE_NAME = 'movies' THEN
+        checked_id := NEW.id;
+    ELSE
+        checked_id := OLD.movie_id;
+    END IF;
+
+    IF EXISTS (
+        SELECT 1 FROM movies m
+        WHERE m.id = checked_id
+        AND m.year > date_part('year', now())
+        AND NOT EXISTS (
+            SELECT 1 FROM releases r
+            WHERE r.movie_id = m.id AND r.release_date > CURRENT_DATE)
+    ) THEN
+        RAISE EXCEPTION 'movies dated in the future must have an upcoming release';
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER movies_upcoming_release_check
+AFTER INSERT OR UPDATE OF year ON movies
+DEFERRABLE INITIALLY DEFERRED
+FOR EACH ROW EXECUTE FUNCTION check_movie_upcoming_release();
+
+CREATE CONSTRAINT TRIGGER releases_upcoming_release_check
+AFTER DELETE OR UPDATE OF movie_id, release_date ON releases
+DEFERRABLE INITIALLY DEFERRED
+FOR EACH ROW EXECUTE FUNCTION check_movie_upcoming_release();
\ No newline at end of file